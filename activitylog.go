@@ -1,12 +1,16 @@
 package audittrail
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
 )
 
 type Transaction struct {
@@ -31,12 +35,30 @@ type Transaction struct {
 	// ActorType is used to store the type of the actor.
 	ActorType string `json:"actorType"`
 
+	// ActorRoles is used to store the realm roles of the actor.
+	ActorRoles []string `json:"actorRoles,omitempty"`
+
+	// ActorClientID is used to store the client the actor authenticated with.
+	ActorClientID string `json:"actorClientId,omitempty"`
+
+	// ActorAuthMethod is used to store how the actor was identified, e.g. "jwt", "mtls" or "apiKey".
+	ActorAuthMethod string `json:"actorAuthMethod,omitempty"`
+
+	// ActorCertificate is used to store the client certificate the actor was identified with.
+	ActorCertificate *CertificateIdentity `json:"actorCertificate,omitempty"`
+
+	// Delegation is used to store on whose behalf the actor acted, e.g. a CRM agent acting as a customer.
+	Delegation *Delegation `json:"delegation,omitempty"`
+
 	// TargetUserID is used to store the keycloak id of the target user.
 	TargetUserID string `json:"targetUserId"`
 
 	// TargetBusinessID is used to store the business id of the target user.
 	TargetBusinessID string `json:"targetBusinessId"`
 
+	// Client is used to store the network identity of the client that sent the request.
+	Client *ClientInfo `json:"client,omitempty"`
+
 	// Target is used to store the endpoint of the event log.
 	Target string `json:"target"`
 
@@ -46,33 +68,114 @@ type Transaction struct {
 	// RequestBody is used to store the request body of the event log.
 	RequestBody map[string]interface{} `json:"requestBody"`
 
+	// RequestBodyValue is used to store a request body that is not an object, e.g. a JSON array.
+	RequestBodyValue interface{} `json:"requestBodyValue,omitempty"`
+
+	// RequestBodyRaw is used to store a request body that could not be decoded.
+	RequestBodyRaw *RawBody `json:"requestBodyRaw,omitempty"`
+
+	// RequestContentLength is used to store the Content-Length of the request, -1 when unknown.
+	RequestContentLength int64 `json:"requestContentLength"`
+
+	// RequestBodySize is used to store the original size of the request body in bytes.
+	RequestBodySize int64 `json:"requestBodySize"`
+
+	// RequestBodyTruncated is used to determine whether the request body was cut to MaxRequestBodyBytes.
+	RequestBodyTruncated bool `json:"requestBodyTruncated"`
+
 	// ResponseBody is used to store the response body of the event log.
 	ResponseBody map[string]interface{} `json:"responseBody"`
 
+	// ResponseBodyValue is used to store a response body that is not an object, e.g. a JSON array.
+	ResponseBodyValue interface{} `json:"responseBodyValue,omitempty"`
+
+	// ResponseBodyRaw is used to store a response body that could not be decoded.
+	ResponseBodyRaw *RawBody `json:"responseBodyRaw,omitempty"`
+
+	// ResponseBodySize is used to store the number of response body bytes written to the client.
+	ResponseBodySize int64 `json:"responseBodySize"`
+
+	// ResponseContentType is used to store the Content-Type of the response.
+	ResponseContentType string `json:"responseContentType"`
+
+	// ResponseBodyTruncated is used to determine whether the response body was cut to MaxResponseBodyBytes.
+	ResponseBodyTruncated bool `json:"responseBodyTruncated"`
+
+	// ResponseStreamed is used to determine whether the response was streamed, flushed or hijacked.
+	// Streamed response bodies are not recorded, only their size.
+	ResponseStreamed bool `json:"responseStreamed"`
+
 	// ResponseCode is used to store the response code of the event log.
 	ResponseCode int `json:"responseCode"`
 
+	// GRPCStatus is used to store the status code and message of a gRPC call.
+	GRPCStatus *GRPCStatus `json:"grpcStatus,omitempty"`
+
 	// Activities is used to store the detail activities of the event log.
 	Activities []Activity `json:"activities"`
 
+	// StatusUpdates is used to store the later statuses of the action logs of earlier event logs, see UpdateActivityStatus.
+	StatusUpdates []ActivityStatusUpdate `json:"statusUpdates,omitempty"`
+
 	TimeStart time.Time `json:"timeStart"`
 	TimeEnd   time.Time `json:"timeEnd"`
 
+	// HandlerLatencyMs is used to store the time spent in the HTTP handler, in milliseconds.
+	HandlerLatencyMs float64 `json:"handlerLatencyMs"`
+
+	// TotalLatencyMs is used to store the time spent in the HTTP middleware, handler included, in milliseconds.
+	TotalLatencyMs float64 `json:"totalLatencyMs"`
+
 	// Resource
 	Resource string `json:"resource"`
 
 	// type
 	Type string `json:"type"`
 
+	// PIIDetected is used to store the summary of the personal data masked before publishing.
+	PIIDetected *PIISummary `json:"piiDetected,omitempty"`
+
+	// Status is used to store the status of the event log.
+	// It is set to "failed" when the handler panics.
+	Status string `json:"status,omitempty"`
+
+	// Error is used to store why the event log failed.
+	Error *ErrorDetail `json:"error,omitempty"`
+
 	// IsHtppMiddleware is used to determine whether the event log is created by the middleware.
 	IsHtppMiddleware bool `json:"-"`
 
 	// Publisher is used to store the publisher of the event log.
 	Publisher message.Publisher `json:"-"`
+
+	// sanitizer is used to mask the captured data before the event log is published.
+	sanitizer *sanitizer
+
+	// patchFormat is used to store the default patch format of the activities, see ActivityLogConfig.PatchFormat.
+	patchFormat PatchFormat
+
+	// isRecordErrorStack is used to determine whether the stack is recorded when an activity fails, see ActivityLogConfig.IsRecordErrorStack.
+	isRecordErrorStack bool
+
+	// mu is used to guard the event log, segments may be started and ended from several goroutines.
+	mu sync.Mutex
+
+	// sequence is used to number the segments in the order they were started.
+	sequence uint64
 }
 
+// TransactionStatusFailed is the status of an event log whose handler panicked.
+const TransactionStatusFailed = "failed"
+
 type Activity struct {
 
+	// ID is used to store the unique ID of the action log.
+	ID string `json:"id"`
+
+	// ParentID is used to store the ID of the parent action log, see Segment.StartChild.
+	// It is empty for the top level action logs.
+	ParentID string `json:"parentId,omitempty"`
+
 	// Action is used to store the action of the action log.
 	Action string `json:"action"`
 
@@ -80,9 +183,11 @@ type Activity struct {
 	// The message will be displayed on CRM.
 	Message string `json:"message"`
 
-	// Status is used to store the status of the action log.
-	// The status can be either "success" or "failed".
-	Status string `json:"status"`
+	// Status is used to store the status of the action log, see ActivityStatus.
+	Status ActivityStatus `json:"status"`
+
+	// Error is used to store why the action log failed, see Segment.Fail.
+	Error *ErrorDetail `json:"error,omitempty"`
 
 	// RequestData is used to store the request data of the action log.
 	RequestData interface{} `json:"requestData"`
@@ -96,13 +201,50 @@ type Activity struct {
 	// DataAfter is used to store the data after the action log.
 	DataAfter interface{} `json:"dataAfter"`
 
+	// Changes is used to store the field level changes between DataBefore and DataAfter.
+	Changes []Change `json:"changes,omitempty"`
+
+	// JSONPatch is used to store the RFC 6902 JSON Patch from DataBefore to DataAfter, see PatchFormat.
+	JSONPatch []PatchOperation `json:"jsonPatch,omitempty"`
+
+	// MergePatch is used to store the RFC 7386 JSON Merge Patch from DataBefore to DataAfter, see PatchFormat.
+	MergePatch interface{} `json:"mergePatch,omitempty"`
+
 	// Timestamp is used to store the timestamp of the action log.
 	Timestamp time.Time `json:"timestamp"`
 
+	// StartTime is used to store the time the action log was started.
+	StartTime time.Time `json:"startTime"`
+
+	// EndTime is used to store the time the action log was ended.
+	EndTime time.Time `json:"endTime"`
+
+	// DurationMs is used to store the time between the start and the end of the action log, in milliseconds.
+	DurationMs float64 `json:"durationMs"`
+
 	// IsVisible is used to determine whether the activity log is visible to the user.
 	IsVisible bool `json:"isVisible"`
+
+	// HTTP is used to store the outgoing HTTP call of the action log, see NewRoundTripper.
+	HTTP *HTTPCall `json:"http,omitempty"`
+
+	// SQL is used to store the write statement of the action log, see WrapSQLDriver.
+	SQL *SQLStatement `json:"sql,omitempty"`
+
+	// sequence is used to order the activities started at the same time.
+	sequence uint64
+}
+
+// To determine whether the activity was started before the other one.
+func (a *Activity) startedBefore(other *Activity) bool {
+	if !a.StartTime.Equal(other.StartTime) {
+		return a.StartTime.Before(other.StartTime)
+	}
+	return a.sequence < other.sequence
 }
 
+// The methods of ITransaction are safe on a nil *Transaction, e.g. the event log of a context
+// without one, see FromContext. They do nothing.
 type ITransaction interface {
 
 	// Start starts a new event log.
@@ -123,6 +265,15 @@ type ITransaction interface {
 	// SetActorType sets the actor type of the event log.
 	SetActorType(actorType string) ITransaction
 
+	// SetOnBehalfOf sets the subject the actor of the event log acts for.
+	SetOnBehalfOf(subject Principal, mode DelegationMode) ITransaction
+
+	// SetDelegationChain sets the actors that delegated to the actor of the event log, the most recent first.
+	SetDelegationChain(chain ...Principal) ITransaction
+
+	// SetAuthorizationReason sets why the actor was authorized to act for the subject.
+	SetAuthorizationReason(reason string) ITransaction
+
 	// GetPayloadTransaction returns the payload byte of the event log.
 	GetPayloadTransaction() []byte
 
@@ -135,62 +286,200 @@ type ITransaction interface {
 	// Set Resource
 	SetResource(resource string) ITransaction
 
+	// UpdateActivityStatus records a later status of an action log of an earlier event log.
+	UpdateActivityStatus(update ActivityStatusUpdate) error
+
 	//Publisher is used to send the event log to the message broker. (Google PubSub)
 	Publish(topicName string)
 }
 
 // Start a new event log
 func (c *Transaction) Start() ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.TimeStart = time.Now()
 	return c
 }
 
 // End the event log
 func (c *Transaction) End() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.TimeEnd = time.Now()
 }
 
 // To create a new event log
 func (c *Transaction) SetTransactionEventType(eventType string) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.EventType = eventType
 	return c
 }
 
 // To Set Actor of the event log
 func (c *Transaction) SetActor(actor string) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Actor = actor
 	return c
 }
 
 // To Set Actor Email of the event log
 func (c *Transaction) SetActorEmail(actorEmail string) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.ActorEmail = actorEmail
 	return c
 }
 
 func (c *Transaction) SetActorType(actorType string) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.ActorType = actorType
 	return c
 }
 
+// To set the subject the actor acts for, e.g. the customer a CRM agent impersonates
+func (c *Transaction) SetOnBehalfOf(subject Principal, mode DelegationMode) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delegation().Subject = subject
+	c.delegation().Mode = mode
+	return c
+}
+
+// To set the actors that delegated to the actor
+func (c *Transaction) SetDelegationChain(chain ...Principal) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delegation().Chain = chain
+	return c
+}
+
+// To set why the actor was authorized to act for the subject
+func (c *Transaction) SetAuthorizationReason(reason string) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delegation().Reason = reason
+	return c
+}
+
+func (c *Transaction) delegation() *Delegation {
+	if c.Delegation == nil {
+		c.Delegation = &Delegation{}
+	}
+	return c.Delegation
+}
+
 func (c *Transaction) SetHeader(header map[string]interface{}) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Header = header
 	return c
 }
 
 func (c *Transaction) SetType(typeString string) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Type = typeString
 	return c
 }
 
 func (c *Transaction) SetResource(resource string) ITransaction {
+	if c == nil {
+		return c
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Resource = resource
 	return c
 }
 
-// To get payload byte of the event log
+// To encode the event log.
+// The audit tags of the activity data are applied, see AuditTag,
+// and the raw JSON bodies of the activities are redacted when masking rules are configured.
+func (c *Transaction) MarshalJSON() ([]byte, error) {
+	type transaction Transaction
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hashKey := processHashKey
+	if c.sanitizer != nil {
+		hashKey = c.sanitizer.hashKey
+	}
+
+	var activities []Activity
+	if c.Activities != nil {
+		activities = make([]Activity, len(c.Activities))
+		for i, activity := range c.Activities {
+			activity.RequestData = applyAuditTags(c.sanitizer.redactRawData(activity.RequestData), hashKey)
+			activity.ResponseData = applyAuditTags(c.sanitizer.redactRawData(activity.ResponseData), hashKey)
+			activity.DataBefore = applyAuditTags(activity.DataBefore, hashKey)
+			activity.DataAfter = applyAuditTags(activity.DataAfter, hashKey)
+			activities[i] = activity
+		}
+	}
+
+	return json.Marshal(&struct {
+		*transaction
+		Activities []Activity `json:"activities"`
+	}{
+		transaction: (*transaction)(c),
+		Activities:  activities,
+	})
+}
+
+// To get payload byte of the event log.
+// The masking rules of the config are applied to a copy, the event log itself is left untouched.
 func (c *Transaction) GetPayloadTransaction() []byte {
 	payload, _ := json.Marshal(c)
+	if c == nil || c.sanitizer == nil {
+		return payload
+	}
+
+	var masked Transaction
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&masked); err != nil {
+		return payload
+	}
+
+	c.sanitizer.sanitize(&masked)
+	payload, _ = json.Marshal(&masked)
 	return payload
 }
 
@@ -210,25 +499,79 @@ func (c *Transaction) GetPayloadTransaction() []byte {
 //	// ... function code here ...
 //
 //	tx.Succeed()
+//
+// Segments are safe for concurrent use, the activities are ordered by the time their segment was started.
+// Without an event log, e.g. on a route skipped by its policy, the segment is not appended to anything.
 func (c *Transaction) StartAction(action string, message string) *Segment {
+	if c == nil {
+		return newDetachedSegment(action, message)
+	}
+	return c.startSegment("", action, message)
+}
+
+// To create a segment without event log, its activity is recorded nowhere.
+func newDetachedSegment(action string, message string) *Segment {
+	return &Segment{Activity: Activity{Action: action, Message: message, Status: ActivityStatusFailed}}
+}
+
+func (c *Transaction) startSegment(parentID string, action string, message string) *Segment {
+	c.mu.Lock()
+	c.sequence++
+	sequence := c.sequence
+	c.mu.Unlock()
+
 	return &Segment{
 		root: c,
 		Activity: Activity{
-			Action:  action,
-			Message: message,
-			Status:  "failed",
+			ID:        uuid.New().String(),
+			ParentID:  parentID,
+			Action:    action,
+			Message:   message,
+			Status:    ActivityStatusFailed,
+			StartTime: time.Now(),
+			sequence:  sequence,
 		},
 	}
 }
 
+// To determine whether the event log has activities or status updates to publish.
+func (c *Transaction) hasActivities() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.Activities) != 0 || len(c.StatusUpdates) != 0
+}
+
+// To insert the activity in the order its segment was started.
+func (c *Transaction) appendActivity(activity Activity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := sort.Search(len(c.Activities), func(i int) bool {
+		return activity.startedBefore(&c.Activities[i])
+	})
+	c.Activities = append(c.Activities, Activity{})
+	copy(c.Activities[i+1:], c.Activities[i:])
+	c.Activities[i] = activity
+}
+
 // Publish
 func (c *Transaction) Publish(topicName string) {
+	if c == nil {
+		return
+	}
 	PublishLog(context.Background(), c.Publisher, c, topicName)
 }
 
 type Segment struct {
-	root     *Transaction
-	Activity Activity
+	root        *Transaction
+	Activity    Activity
+	diffOptions DiffOptions
+
+	// mu is used to guard the activity of the segment.
+	mu sync.Mutex
 }
 
 type ISegment interface {
@@ -259,58 +602,214 @@ type ISegment interface {
 
 	// Succeed marks the action log as successful.
 	Succeed() ISegment
+
+	// SetStatus sets the status of the action log, e.g. pending or partial.
+	// An unknown status, see ActivityStatus.IsValid, is ignored and the action log keeps its status.
+	SetStatus(status ActivityStatus) ISegment
+
+	// SetDiffOptions sets how the changes between the data before and after are computed.
+	SetDiffOptions(options DiffOptions) ISegment
+
+	// StartChild starts a new action log nested in the action log.
+	StartChild(action string, message string) *Segment
+
+	// Fail marks the action log as failed, and records why.
+	Fail(err error) ISegment
+
+	// Finish ends the action log, marking it as successful unless the error is set.
+	Finish(err *error)
 }
 
 // To set the actor keycloak ID of the event log
 func (c *Segment) SetTargetUserID(userID string) ISegment {
+	if c.root == nil {
+		return c
+	}
+	c.root.mu.Lock()
+	defer c.root.mu.Unlock()
 	c.root.TargetUserID = userID
 	return c
 }
 
 // To set the actor business ID of the event log
 func (c *Segment) SetTargetBusinessID(businessID int64) ISegment {
+	if c.root == nil {
+		return c
+	}
+	c.root.mu.Lock()
+	defer c.root.mu.Unlock()
 	c.root.TargetBusinessID = strconv.Itoa(int(businessID))
 	return c
 }
 
 // To set the data before change
 func (c *Segment) SetDataBefore(data interface{}) ISegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Activity.DataBefore = data
 	return c
 }
 
 // To set the data after change
 func (c *Segment) SetDataAfter(data interface{}) ISegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Activity.DataAfter = data
 	return c
 }
 
 // To set the request data of the action log
 func (c *Segment) SetRequestData(data interface{}) ISegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Activity.RequestData = data
 	return c
 }
 
 // To set the response data of the action log
 func (c *Segment) SetResponseData(data interface{}) ISegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Activity.ResponseData = data
 	return c
 }
 
 // To set the request data of the action log
 func (c *Segment) Succeed() ISegment {
-	c.Activity.Status = "success"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Activity.Status = ActivityStatusSuccess
 	return c
 }
 
+// To set the status of the action log.
+// An unknown status, see ActivityStatus.IsValid, is ignored and the action log keeps its status,
+// so a typo never records a status the consumers do not know. Validate statuses built from input before.
+// The status can be updated later, after the action log ended, with Transaction.UpdateActivityStatus.
+func (c *Segment) SetStatus(status ActivityStatus) ISegment {
+	if !status.IsValid() {
+		return c
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Activity.Status = status
+	return c
+}
+
+// To mark the action log as failed, with the message, type and code of the error.
+// The stack of the caller is also recorded when the config sets IsRecordErrorStack.
+func (c *Segment) Fail(err error) ISegment {
+	return c.fail(err, 2)
+}
+
+func (c *Segment) fail(err error, skip int) ISegment {
+	isRecordStack := c.root != nil && c.root.isRecordErrorStack
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Activity.Status = ActivityStatusFailed
+	if err != nil {
+		c.Activity.Error = newErrorDetail(err, isRecordStack, skip+1)
+	}
+	return c
+}
+
+// To end the action log, marking it as failed with the error, or as successful when there is none.
+// A panic is recorded and propagated.
+//
+// Example:
+//
+//	func (s *Service) Approve(ctx context.Context, id string) (err error) {
+//		ctx, activity := activitylog.StartActionCtx(ctx, "approve", "approve loan")
+//		defer activity.Finish(&err)
+//
+//		// ... function code here ...
+//	}
+func (c *Segment) Finish(err *error) {
+	if recovered := recover(); recovered != nil {
+		c.mu.Lock()
+		c.Activity.Status = ActivityStatusFailed
+		c.Activity.Error = newPanicError(recovered, 0)
+		c.mu.Unlock()
+		c.End()
+		panic(recovered)
+	}
+
+	if err != nil && *err != nil {
+		c.fail(*err, 2)
+	} else {
+		c.Succeed()
+	}
+	c.End()
+}
+
 // To set the visibility of the action log
 func (c *Segment) SetVisibility(isVisible bool) ISegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Activity.IsVisible = isVisible
 	return c
 }
 
+// To set how the changes between the data before and after are computed
+func (c *Segment) SetDiffOptions(options DiffOptions) ISegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.diffOptions = options
+	return c
+}
+
+// To start a new action log nested in the action log, e.g. a step of the action.
+// The child is appended to the event log on its own End, with the ID of the action log as its parent ID.
+func (c *Segment) StartChild(action string, message string) *Segment {
+	if c.root == nil {
+		return newDetachedSegment(action, message)
+	}
+
+	c.mu.Lock()
+	parentID := c.Activity.ID
+	c.mu.Unlock()
+
+	return c.root.startSegment(parentID, action, message)
+}
+
 // To Append the action log to the event log
+// The changes and patches are computed when both the data before and after are set.
+// Segments started without an event log, see StartActionCtx, are not appended.
 func (c *Segment) End() {
-	c.Activity.Timestamp = time.Now()
-	c.root.Activities = append(c.root.Activities, c.Activity)
+	if c.root == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Activity.EndTime = time.Now()
+	c.Activity.Timestamp = c.Activity.EndTime
+	c.Activity.DurationMs = durationMs(c.Activity.EndTime.Sub(c.Activity.StartTime))
+
+	if c.Activity.DataBefore != nil && c.Activity.DataAfter != nil {
+		hashKey := processHashKey
+		if c.root.sanitizer != nil {
+			hashKey = c.root.sanitizer.hashKey
+		}
+
+		c.Activity.Changes = diffValues(c.Activity.DataBefore, c.Activity.DataAfter, c.diffOptions, hashKey)
+
+		format := c.diffOptions.PatchFormat
+		if format == "" {
+			format = c.root.patchFormat
+		}
+		if format != "" {
+			c.Activity.setPatches(format, hashKey)
+		}
+
+		if c.diffOptions.IsDiffOnly {
+			c.Activity.DataBefore = nil
+			c.Activity.DataAfter = nil
+		}
+	}
+
+	c.root.appendActivity(c.Activity)
 }
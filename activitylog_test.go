@@ -2,6 +2,8 @@ package audittrail
 
 import (
 	"encoding/json"
+	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -75,3 +77,129 @@ func TestTransactionAndSegmentMethods(t *testing.T) {
 		}
 	})
 }
+
+func TestConcurrentSegments(t *testing.T) {
+	transaction := &Transaction{}
+
+	const count = 50
+	segments := make([]*Segment, count)
+	for i := range segments {
+		segments[i] = transaction.StartAction("action"+strconv.Itoa(i), "message")
+	}
+
+	var wg sync.WaitGroup
+	for i := range segments {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// End the segments in the reverse order they were started
+			segment := segments[count-1-i]
+			segment.SetTargetUserID("user" + strconv.Itoa(i))
+			segment.SetTargetBusinessID(int64(i))
+			segment.SetDataBefore(map[string]interface{}{"index": i})
+			segment.SetDataAfter(map[string]interface{}{"index": i + 1})
+			segment.Succeed()
+			segment.End()
+
+			transaction.GetPayloadTransaction()
+		}(i)
+	}
+	wg.Wait()
+
+	if len(transaction.Activities) != count {
+		t.Fatalf("Expected %d activities, but got %d", count, len(transaction.Activities))
+	}
+	for i, activity := range transaction.Activities {
+		if activity.Action != "action"+strconv.Itoa(i) {
+			t.Errorf("Expected activity %d to be %s, but got %s", i, "action"+strconv.Itoa(i), activity.Action)
+		}
+	}
+}
+
+func TestConcurrentStartAction(t *testing.T) {
+	transaction := &Transaction{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			segment := transaction.StartAction("action", "message")
+			transaction.SetResource("loan")
+			segment.Succeed().End()
+		}()
+	}
+	wg.Wait()
+
+	if len(transaction.Activities) != 50 {
+		t.Errorf("Expected %d activities, but got %d", 50, len(transaction.Activities))
+	}
+	for i := 1; i < len(transaction.Activities); i++ {
+		if !transaction.Activities[i-1].startedBefore(&transaction.Activities[i]) {
+			t.Errorf("Expected activities to be ordered by start, but %d is not", i)
+		}
+	}
+}
+
+func TestSegmentStartChild(t *testing.T) {
+	transaction := &Transaction{}
+
+	approve := transaction.StartAction("approve", "approve loan")
+	check := approve.StartChild("check", "check limit")
+	bureau := check.StartChild("bureau", "call bureau")
+	bureau.Succeed().End()
+	check.Succeed().End()
+	ledger := approve.StartChild("ledger", "update ledger")
+	ledger.Succeed().End()
+	approve.Succeed().End()
+
+	var result Transaction
+	json.Unmarshal(transaction.GetPayloadTransaction(), &result)
+
+	if len(result.Activities) != 4 {
+		t.Fatalf("Expected 4 activities, but got %d", len(result.Activities))
+	}
+
+	parents := map[string]string{}
+	ids := map[string]string{}
+	for _, activity := range result.Activities {
+		if activity.ID == "" {
+			t.Errorf("Expected activity %s to have an ID", activity.Action)
+		}
+		ids[activity.Action] = activity.ID
+		parents[activity.Action] = activity.ParentID
+
+		if activity.EndTime.Before(activity.StartTime) || activity.DurationMs < 0 {
+			t.Errorf("Expected activity %s to end after its start, but got %v and %v", activity.Action, activity.StartTime, activity.EndTime)
+		}
+	}
+
+	expected := map[string]string{"approve": "", "check": ids["approve"], "bureau": ids["check"], "ledger": ids["approve"]}
+	for action, parentID := range expected {
+		if parents[action] != parentID {
+			t.Errorf("Expected parent of %s to be %s, but got %s", action, parentID, parents[action])
+		}
+	}
+
+	if result.Activities[0].Action != "approve" || result.Activities[3].Action != "ledger" {
+		t.Errorf("Expected activities to be ordered by start, but got %s first and %s last", result.Activities[0].Action, result.Activities[3].Action)
+	}
+}
+
+func TestNilTransaction(t *testing.T) {
+	var transaction *Transaction
+
+	transaction.Start()
+	transaction.SetTransactionEventType("testEvent").SetActor("testActor").SetResource("loan")
+	transaction.StartAction("testAction", "testMessage").Succeed().End()
+	transaction.End()
+	transaction.Publish("testTopic")
+
+	if err := transaction.UpdateActivityStatus(ActivityStatusUpdate{EventID: "event", ActivityID: "activity", Status: ActivityStatusSuccess}); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+	if payload := string(transaction.GetPayloadTransaction()); payload != "null" {
+		t.Errorf("Expected payload to be %s, but got %s", "null", payload)
+	}
+}
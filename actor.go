@@ -0,0 +1,140 @@
+package audittrail
+
+import (
+	"errors"
+	"net/http"
+
+	"bitbucket.org/tunaiku/amargo-core/pkg/logger"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// ResolvedActor is used to store the identity of the actor resolved from the request.
+type ResolvedActor struct {
+
+	// ID is used to store the keycloak id of the actor.
+	ID string
+
+	// Email is used to store the email of the actor.
+	Email string
+
+	// Type is used to store the type of the actor. The config ActorType is kept when empty.
+	Type string
+
+	// Roles is used to store the realm roles of the actor.
+	Roles []string
+
+	// ClientID is used to store the client the actor authenticated with.
+	ClientID string
+
+	// Method is used to store how the actor was identified, e.g. "jwt", "mtls" or "apiKey".
+	Method string
+
+	// Certificate is used to store the client certificate the actor was identified with.
+	Certificate *CertificateIdentity
+
+	// Delegation is used to store on whose behalf the actor acts, e.g. from the "act" claim of a token.
+	Delegation *Delegation
+}
+
+// ActorResolver is used to attribute every event log to the actor of the request.
+type ActorResolver interface {
+
+	// ResolveActor returns the actor of the request.
+	// It returns nil and no error when the request does not carry an identity it knows.
+	ResolveActor(r *http.Request) (*ResolvedActor, error)
+}
+
+// ActorResolverFunc is an adapter to use a function as an ActorResolver.
+type ActorResolverFunc func(r *http.Request) (*ResolvedActor, error)
+
+func (f ActorResolverFunc) ResolveActor(r *http.Request) (*ResolvedActor, error) {
+	return f(r)
+}
+
+// ChainActorResolvers creates an ActorResolver that tries the resolvers in priority order.
+// The first resolved actor wins. The error of a resolver is only returned
+// when none of the following resolvers resolves the actor.
+func ChainActorResolvers(resolvers ...ActorResolver) ActorResolver {
+	return chainActorResolver(resolvers)
+}
+
+type chainActorResolver []ActorResolver
+
+func (c chainActorResolver) ResolveActor(r *http.Request) (*ResolvedActor, error) {
+	var firstErr error
+	for _, resolver := range c {
+		actor, err := resolver.ResolveActor(r)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if actor != nil {
+			return actor, nil
+		}
+	}
+	return nil, firstErr
+}
+
+func (c chainActorResolver) sensitiveHeaders() []string {
+	var headers []string
+	for _, resolver := range c {
+		headers = append(headers, actorSensitiveHeaders(resolver)...)
+	}
+	return headers
+}
+
+// sensitiveHeaderResolver is implemented by the resolvers that read credentials from custom headers,
+// so the headers are masked in the event log.
+type sensitiveHeaderResolver interface {
+	sensitiveHeaders() []string
+}
+
+// To get the headers holding the credentials the resolver reads.
+func actorSensitiveHeaders(resolver ActorResolver) []string {
+	if resolver, ok := resolver.(sensitiveHeaderResolver); ok {
+		return resolver.sensitiveHeaders()
+	}
+	return nil
+}
+
+// To fill the actor of the event log with the identity resolved from the request.
+// The request is never rejected, an identity that can not be resolved is only logged.
+// Expired tokens are routine, so they are logged at debug level only.
+func resolveActor(resolver ActorResolver, r *http.Request, log *Transaction) {
+	if resolver == nil {
+		return
+	}
+
+	actor, err := resolver.ResolveActor(r)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		logger.IWithTraceId(r.Context()).Debug("activity log actor token expired ", logrus.Fields{
+			"err": err,
+		})
+		return
+	}
+	if err != nil {
+		logger.IWithTraceId(r.Context()).Error("failed to resolve activity log actor ", logrus.Fields{
+			"err": err,
+		})
+		return
+	}
+	if actor == nil {
+		return
+	}
+
+	log.Actor = actor.ID
+	log.ActorRoles = actor.Roles
+	log.ActorClientID = actor.ClientID
+	log.ActorAuthMethod = actor.Method
+	log.ActorCertificate = actor.Certificate
+	log.Delegation = actor.Delegation
+	if actor.Email != "" {
+		log.ActorEmail = actor.Email
+	}
+	if actor.Type != "" {
+		log.ActorType = actor.Type
+	}
+}
@@ -0,0 +1,370 @@
+package audittrail
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AuditTag is the struct tag used to protect sensitive fields of the values
+// passed to the Segment setters. It is honoured when the activities are serialized.
+//
+//	type Customer struct {
+//		Name     string `json:"name"`
+//		Password string `json:"password" audit:"-"`          // removed
+//		NIK      string `json:"nik" audit:"hash"`            // keyed hash
+//		Phone    string `json:"phone" audit:"mask"`          // replaced with ***
+//		Notes    string `json:"notes" audit:"truncate=64"`   // cut to 64 characters
+//	}
+const AuditTag = "audit"
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+	// auditTaggedTypes caches whether a type contains an audit tag.
+	auditTaggedTypes sync.Map
+)
+
+// To convert the value into its JSON form with the audit tags applied.
+// Values whose type does not contain any audit tag are returned as is.
+func applyAuditTags(value interface{}, hashKey []byte) interface{} {
+	if value == nil || !hasAuditTags(reflect.TypeOf(value)) {
+		return value
+	}
+	encoder := &auditEncoder{hashKey: hashKey, visiting: map[auditPointer]bool{}}
+	return encoder.value(reflect.ValueOf(value))
+}
+
+// auditEncoder converts a value into its JSON form with the audit tags applied.
+type auditEncoder struct {
+	hashKey []byte
+
+	// visiting is used to store the pointers, maps and slices being converted,
+	// so a cycle is cut instead of recursing forever.
+	visiting map[auditPointer]bool
+}
+
+type auditPointer struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// To check whether the type, or any type nested in it, contains an audit tag.
+func hasAuditTags(t reflect.Type) bool {
+	if cached, ok := auditTaggedTypes.Load(t); ok {
+		return cached.(bool)
+	}
+	tagged := typeHasAuditTags(t, map[reflect.Type]bool{})
+	auditTaggedTypes.Store(t, tagged)
+	return tagged
+}
+
+func typeHasAuditTags(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+
+	if t.Kind() == reflect.Interface {
+		// The dynamic value is only known at runtime.
+		return true
+	}
+
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return typeHasAuditTags(t.Elem(), visited)
+	case reflect.Map:
+		return typeHasAuditTags(t.Elem(), visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if _, ok := field.Tag.Lookup(AuditTag); ok {
+				return true
+			}
+			if typeHasAuditTags(field.Type, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// To convert the value into its JSON form, following the encoding/json rules.
+// A value that refers back to itself is cut with null where the cycle closes.
+func (e *auditEncoder) value(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		key := auditPointer{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			key.len = v.Len()
+		}
+		if e.visiting[key] {
+			return nil
+		}
+		e.visiting[key] = true
+		defer delete(e.visiting, key)
+	}
+
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Interface || !v.Type().Implements(jsonMarshalerType) {
+			return e.value(v.Elem())
+		}
+	}
+
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) ||
+		(v.CanAddr() && (v.Addr().Type().Implements(jsonMarshalerType) || v.Addr().Type().Implements(textMarshalerType))) {
+		return decodeMarshaled(v)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		result := make(map[string]interface{})
+		e.structFields(v, result)
+		return result
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		result := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			result[mapKeyString(iter.Key())] = e.value(iter.Value())
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return decodeMarshaled(v)
+		}
+		result := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result[i] = e.value(v.Index(i))
+		}
+		return result
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return nil
+	default:
+		return v.Interface()
+	}
+}
+
+// auditField is used to store a field of the JSON form of a struct, with the depth it is embedded at.
+type auditField struct {
+	depth int
+
+	// tagged is used to determine whether the name comes from the json tag.
+	tagged bool
+
+	// omitted is used to determine whether the field claims its name without a value,
+	// e.g. an empty omitempty field, a field tagged audit:"-" or a field of a nil embedded struct.
+	omitted bool
+
+	// conflict is used to determine whether fields at the same depth claim the name, so none of them is kept.
+	conflict bool
+
+	value interface{}
+}
+
+// To convert the fields of the struct into the result, applying the audit tags.
+// Embedded structs without a JSON name are flattened like encoding/json does,
+// a field at a shallower depth hides the fields of the same name embedded deeper.
+func (e *auditEncoder) structFields(v reflect.Value, result map[string]interface{}) {
+	fields := make(map[string]auditField)
+	e.embeddedFields(v, 0, false, map[reflect.Type]bool{}, fields)
+
+	for name, field := range fields {
+		if !field.conflict && !field.omitted {
+			result[name] = field.value
+		}
+	}
+}
+
+// To collect the fields of the struct embedded at the depth, absent when it is embedded through a nil pointer.
+// The embedding types are used to stop at a struct embedding itself.
+func (e *auditEncoder) embeddedFields(v reflect.Value, depth int, absent bool, embedding map[reflect.Type]bool, fields map[string]auditField) {
+	t := v.Type()
+	if embedding[t] {
+		return
+	}
+	embedding[t] = true
+	defer delete(embedding, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		name, omitEmpty, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+
+		fv := v.Field(i)
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						e.embeddedFields(reflect.Zero(ft), depth+1, true, embedding, fields)
+						continue
+					}
+					fv = fv.Elem()
+				}
+				e.embeddedFields(fv, depth+1, absent, embedding, fields)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+		}
+
+		candidate := auditField{depth: depth, tagged: name != "", omitted: absent}
+		if name == "" {
+			name = field.Name
+		}
+
+		if existing, ok := fields[name]; ok {
+			if existing.depth < depth || (existing.depth == depth && existing.tagged && !candidate.tagged) {
+				continue
+			}
+			if existing.depth == depth && existing.tagged == candidate.tagged {
+				existing.conflict = true
+				fields[name] = existing
+				continue
+			}
+		}
+
+		tag, tagged := field.Tag.Lookup(AuditTag)
+		switch {
+		case candidate.omitted:
+		case omitEmpty && isEmptyJSONValue(fv), tagged && tag == "-":
+			candidate.omitted = true
+		case tagged:
+			candidate.value = applyAuditTag(tag, e.value(fv), e.hashKey)
+		default:
+			candidate.value = e.value(fv)
+		}
+		fields[name] = candidate
+	}
+}
+
+// To check whether omitempty drops the value, with the encoding/json rules:
+// false, 0, nil pointers and interfaces, and empty arrays, slices, maps and strings are empty, structs never are.
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// To apply the audit tag directive to the value of the field.
+// Unknown directives mask the value, so a typo never leaks the data.
+func applyAuditTag(tag string, value interface{}, hashKey []byte) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	switch {
+	case tag == "hash":
+		return "hmac-sha256:" + keyedHash(hashKey, stringifyJSON(value))
+	case strings.HasPrefix(tag, "truncate="):
+		limit, err := strconv.Atoi(strings.TrimPrefix(tag, "truncate="))
+		if err != nil || limit < 0 {
+			return MaskedValue
+		}
+		runes := []rune(stringifyJSON(value))
+		if len(runes) <= limit {
+			return value
+		}
+		return string(runes[:limit])
+	default:
+		return MaskedValue
+	}
+}
+
+// To get the JSON name of the field.
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return parts[0], omitEmpty, false
+}
+
+func mapKeyString(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		text, _ := tm.MarshalText()
+		return string(text)
+	}
+	return fmt.Sprint(k.Interface())
+}
+
+// To convert a value that takes care of its own encoding into its JSON form.
+func decodeMarshaled(v reflect.Value) interface{} {
+	var value interface{}
+	if v.CanAddr() {
+		value = v.Addr().Interface()
+	} else {
+		value = v.Interface()
+	}
+
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+
+	var result interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil
+	}
+	return result
+}
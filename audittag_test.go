@@ -0,0 +1,199 @@
+package audittrail
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type auditTestAddress struct {
+	Street string `json:"street"`
+	Phone  string `json:"phone" audit:"mask"`
+}
+
+type auditTestBase struct {
+	ID int64 `json:"id"`
+}
+
+type auditTestCustomer struct {
+	auditTestBase
+	Name      string                       `json:"name"`
+	Password  string                       `json:"password" audit:"-"`
+	NIK       string                       `json:"nik" audit:"hash"`
+	Notes     string                       `json:"notes" audit:"truncate=5"`
+	Address   *auditTestAddress            `json:"address"`
+	Previous  []auditTestAddress           `json:"previous"`
+	Contacts  map[string]*auditTestAddress `json:"contacts"`
+	CreatedAt time.Time                    `json:"createdAt"`
+	Secret    string                       `json:"secret,omitempty" audit:"mask"`
+	Token     string                       `json:"token" audit:"unknown"`
+}
+
+func TestAuditTags(t *testing.T) {
+	customer := &auditTestCustomer{
+		auditTestBase: auditTestBase{ID: 7},
+		Name:          "Budi",
+		Password:      "secret",
+		NIK:           "3201234567890001",
+		Notes:         "a very long note",
+		Address:       &auditTestAddress{Street: "Jl. Sudirman", Phone: "+628123456789"},
+		Previous:      []auditTestAddress{{Street: "Jl. Thamrin", Phone: "+628111111111"}},
+		Contacts:      map[string]*auditTestAddress{"office": {Street: "Jl. Gatot Subroto", Phone: "+628222222222"}},
+		CreatedAt:     time.Date(2024, 8, 8, 0, 0, 0, 0, time.UTC),
+		Token:         "token",
+	}
+
+	transaction := &Transaction{}
+	segment := transaction.StartAction("update", "update customer")
+	segment.SetDataAfter(customer)
+	segment.SetDataBefore("plain value")
+	segment.End()
+
+	payload := string(transaction.GetPayloadTransaction())
+	for _, leaked := range []string{"secret", "3201234567890001", "+628", "a very long note", `:"token"`} {
+		if strings.Contains(payload, leaked) {
+			t.Errorf("Expected payload not to contain %s, but got %s", leaked, payload)
+		}
+	}
+
+	var result Transaction
+	if err := json.Unmarshal([]byte(payload), &result); err != nil {
+		t.Fatalf("Error decoding payload: %v", err)
+	}
+
+	after := result.Activities[0].DataAfter.(map[string]interface{})
+
+	if after["id"] != float64(7) {
+		t.Errorf("Expected id to be %d, but got %v", 7, after["id"])
+	}
+
+	if _, ok := after["password"]; ok {
+		t.Errorf("Expected password to be removed, but got %v", after["password"])
+	}
+
+	if !strings.HasPrefix(after["nik"].(string), "hmac-sha256:") {
+		t.Errorf("Expected nik to be hashed, but got %v", after["nik"])
+	}
+
+	if after["notes"] != "a ver" {
+		t.Errorf("Expected notes to be %s, but got %v", "a ver", after["notes"])
+	}
+
+	if after["address"].(map[string]interface{})["phone"] != MaskedValue {
+		t.Errorf("Expected address phone to be masked, but got %v", after["address"])
+	}
+
+	if after["previous"].([]interface{})[0].(map[string]interface{})["phone"] != MaskedValue {
+		t.Errorf("Expected previous phone to be masked, but got %v", after["previous"])
+	}
+
+	if after["contacts"].(map[string]interface{})["office"].(map[string]interface{})["phone"] != MaskedValue {
+		t.Errorf("Expected contact phone to be masked, but got %v", after["contacts"])
+	}
+
+	if after["createdAt"] != "2024-08-08T00:00:00Z" {
+		t.Errorf("Expected createdAt to be %s, but got %v", "2024-08-08T00:00:00Z", after["createdAt"])
+	}
+
+	if _, ok := after["secret"]; ok {
+		t.Errorf("Expected empty secret to be omitted, but got %v", after["secret"])
+	}
+
+	if after["token"] != MaskedValue {
+		t.Errorf("Expected token to be masked, but got %v", after["token"])
+	}
+
+	if result.Activities[0].DataBefore != "plain value" {
+		t.Errorf("Expected DataBefore to be %s, but got %v", "plain value", result.Activities[0].DataBefore)
+	}
+
+	if customer.Password != "secret" {
+		t.Errorf("Expected the value to be left untouched, but got %s", customer.Password)
+	}
+}
+
+type auditTestNode struct {
+	Name      string         `json:"name"`
+	Secret    string         `json:"secret" audit:"mask"`
+	Next      *auditTestNode `json:"next"`
+	Tags      []string       `json:"tags,omitempty"`
+	CreatedAt time.Time      `json:"createdAt,omitempty"`
+	Count     *int           `json:"count,omitempty"`
+}
+
+func TestAuditTagsFollowEncodingJSON(t *testing.T) {
+	zero := 0
+	node := &auditTestNode{Name: "a", Secret: "secret", Tags: []string{}, Count: &zero}
+	node.Next = &auditTestNode{Name: "b", Next: node}
+
+	result, ok := applyAuditTags(node, processHashKey).(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected an object, but got %v", result)
+	}
+
+	if _, ok := result["tags"]; ok {
+		t.Errorf("Expected the empty tags to be omitted, but got %v", result["tags"])
+	}
+	if _, ok := result["createdAt"]; !ok {
+		t.Errorf("Expected the zero createdAt to be kept, as structs are never empty")
+	}
+	if result["count"] != 0 {
+		t.Errorf("Expected the pointer to zero to be kept, but got %v", result["count"])
+	}
+
+	next := result["next"].(map[string]interface{})
+	if next["name"] != "b" || next["next"] != nil {
+		t.Errorf("Expected the cycle to be cut at the repeated pointer, but got %v", next)
+	}
+}
+
+type auditTestProfile struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+	ID     string `json:"id"`
+	Email  string `json:"email" audit:"mask"`
+}
+
+type auditTestAccount struct {
+	ID string `json:"id"`
+}
+
+type auditTestUser struct {
+	auditTestProfile
+	*auditTestAccount
+	Name   string `json:"name"`
+	Secret string `json:"secret" audit:"-"`
+}
+
+func TestAuditTagsShallowerFieldsWin(t *testing.T) {
+	user := auditTestUser{
+		auditTestProfile: auditTestProfile{Name: "embedded", Secret: "hunter2", ID: "profile", Email: "budi@example.com"},
+		Name:             "outer",
+		Secret:           "secret",
+	}
+
+	result, ok := applyAuditTags(user, processHashKey).(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected an object, but got %v", result)
+	}
+
+	if result["name"] != "outer" {
+		t.Errorf("Expected name to be %s, but got %v", "outer", result["name"])
+	}
+	if _, ok := result["secret"]; ok {
+		t.Errorf("Expected the removed secret to hide the embedded one, but got %v", result["secret"])
+	}
+	if _, ok := result["id"]; ok {
+		t.Errorf("Expected the ids embedded at the same depth to cancel out, also through a nil pointer, but got %v", result["id"])
+	}
+	if result["email"] != MaskedValue {
+		t.Errorf("Expected email to be %s, but got %v", MaskedValue, result["email"])
+	}
+
+	user.auditTestAccount = &auditTestAccount{ID: "account"}
+	result = applyAuditTags(user, processHashKey).(map[string]interface{})
+	if _, ok := result["id"]; ok {
+		t.Errorf("Expected the ids embedded at the same depth to cancel out, but got %v", result["id"])
+	}
+}
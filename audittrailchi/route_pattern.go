@@ -0,0 +1,44 @@
+// Package audittrailchi resolves the route patterns of the chi v5 router for the activity log middleware.
+// The chi v1-v4 router is supported by the audittrail package itself, see audittrail.ChiRoutePattern.
+package audittrailchi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RoutePattern resolves the route pattern of the chi v5 router the request is served by.
+//
+// Example:
+//
+//	cfg.RoutePatternResolver = audittrailchi.RoutePattern
+//	r.Use(audittrail.NewHTTPMiddleware(publisher, cfg))
+func RoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+
+	if pattern := rctx.RoutePattern(); pattern != "" {
+		return pattern
+	}
+	if rctx.Routes == nil {
+		return ""
+	}
+
+	// The middleware runs before the routing, so match the routes on a new context
+	tctx := chi.NewRouteContext()
+	if !rctx.Routes.Match(tctx, r.Method, routePath(r)) {
+		return ""
+	}
+	return tctx.RoutePattern()
+}
+
+// To get the path the router matches.
+func routePath(r *http.Request) string {
+	if r.URL.RawPath != "" {
+		return r.URL.RawPath
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,29 @@
+package audittrailchi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	audittrail "github.com/raihansuwanto/audit-trail"
+)
+
+func TestRoutePattern(t *testing.T) {
+	var target string
+	router := chi.NewRouter()
+	router.Use(audittrail.NewHTTPMiddleware(nil, audittrail.ActivityLogConfig{RoutePatternResolver: RoutePattern}))
+	router.Get("/loans/{id}", func(w http.ResponseWriter, r *http.Request) {
+		target = audittrail.FromContext(r.Context()).Target
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/loans/42", nil))
+
+	if target != "GET /loans/{id}" {
+		t.Errorf("Expected target to be %s, but got %s", "GET /loans/{id}", target)
+	}
+
+	if pattern := RoutePattern(httptest.NewRequest("GET", "/unknown/42", nil)); pattern != "" {
+		t.Errorf("Expected no pattern outside the router, but got %s", pattern)
+	}
+}
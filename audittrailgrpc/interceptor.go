@@ -0,0 +1,300 @@
+// Package audittrailgrpc records the calls of a gRPC server as activity event logs,
+// like audittrail.NewHTTPMiddleware does for net/http.
+package audittrailgrpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	audittrail "github.com/raihansuwanto/audit-trail"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpcContentType is the content type the gRPC messages are recorded with, as protojson.
+const grpcContentType = "application/json"
+
+// NewUnaryServerInterceptor creates the activity log interceptor for unary gRPC calls.
+// The full method is recorded as the target and matched against the route policies,
+// e.g. "/loan.v1.LoanService/*". The messages are recorded as protojson.
+// The ActorResolver and DelegationHeaders read the incoming metadata as request headers.
+func NewUnaryServerInterceptor(publisher message.Publisher, cfg audittrail.ActivityLogConfig) grpc.UnaryServerInterceptor {
+	recorder := audittrail.NewCallRecorder(publisher, cfg)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		call := start(ctx, recorder, info.FullMethod)
+		if call == nil {
+			return handler(ctx, req)
+		}
+
+		if limit := call.RequestBodyLimit(); limit > 0 {
+			data, size, truncated := captureMessage(req, limit)
+			call.SetRequestBody(grpcContentType, data, size, truncated)
+		}
+
+		ctx = audittrail.NewContext(ctx, call.Log)
+
+		defer func() {
+			// Record the panic of the handler, then let the upstream recoverers handle it
+			recovered := recover()
+
+			if limit := call.ResponseBodyLimit(); limit > 0 && recovered == nil {
+				data, size, truncated := captureMessage(resp, limit)
+				call.SetResponseBody(grpcContentType, data, size, truncated)
+			}
+
+			finish(ctx, call, err, recovered)
+
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+
+		call.RunHandler(func() {
+			resp, err = handler(ctx, req)
+		})
+		return resp, err
+	}
+}
+
+// NewStreamServerInterceptor creates the activity log interceptor for streaming gRPC calls.
+// The received and sent messages are recorded as protojson arrays, up to the configured body limits.
+func NewStreamServerInterceptor(publisher message.Publisher, cfg audittrail.ActivityLogConfig) grpc.StreamServerInterceptor {
+	recorder := audittrail.NewCallRecorder(publisher, cfg)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		call := start(ss.Context(), recorder, info.FullMethod)
+		if call == nil {
+			return handler(srv, ss)
+		}
+
+		stream := &recordedServerStream{
+			ServerStream: ss,
+			ctx:          audittrail.NewContext(ss.Context(), call.Log),
+			received:     newMessageRecorder(call.RequestBodyLimit()),
+			sent:         newMessageRecorder(call.ResponseBodyLimit()),
+		}
+
+		defer func() {
+			// Record the panic of the handler, then let the upstream recoverers handle it
+			recovered := recover()
+
+			// The arrays stay valid when messages are left out, so they are decoded as whole
+			if stream.received.limit > 0 {
+				call.SetRequestBody(grpcContentType, stream.received.json(), stream.received.size, false)
+				call.Log.RequestBodyTruncated = stream.received.truncated
+			}
+			if stream.sent.limit > 0 {
+				call.SetResponseBody(grpcContentType, stream.sent.json(), stream.sent.size, false)
+				call.Log.ResponseBodyTruncated = stream.sent.truncated
+			}
+
+			finish(stream.ctx, call, err, recovered)
+
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+
+		call.RunHandler(func() {
+			err = handler(srv, stream)
+		})
+		return err
+	}
+}
+
+// To create the event log of the call, unless its route policy skips it.
+// The full method is the target of the event log.
+func start(ctx context.Context, recorder *audittrail.CallRecorder, fullMethod string) *audittrail.Call {
+	call := recorder.Start(grpcRequest(ctx, fullMethod), fullMethod)
+	if call != nil {
+		call.Log.Target = fullMethod
+	}
+	return call
+}
+
+// To record the status of the call and publish the event log.
+// The response code is the HTTP equivalent of the status, see HTTPStatus.
+func finish(ctx context.Context, call *audittrail.Call, err error, recovered interface{}) {
+	st := status.Convert(err)
+	if recovered != nil {
+		st = status.New(codes.Internal, fmt.Sprint(recovered))
+	}
+
+	if call.Config.IsRecordResponseCode {
+		call.Log.GRPCStatus = &audittrail.GRPCStatus{Code: st.Code().String(), Message: st.Message()}
+	}
+	call.Finish(ctx, HTTPStatus(st.Code()), recovered)
+}
+
+// HTTPStatus maps the gRPC status code to its HTTP equivalent, as grpc-gateway does,
+// so the response code of gRPC calls reads the same as the one of HTTP requests.
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// To describe the gRPC call as a request, so the actor resolvers, header redaction
+// and client info work on the incoming metadata and the peer of the call.
+func grpcRequest(ctx context.Context, fullMethod string) *http.Request {
+	r := &http.Request{
+		Method:        http.MethodPost,
+		URL:           &url.URL{Path: fullMethod},
+		RequestURI:    fullMethod,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        http.Header{},
+		ContentLength: -1,
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			if key == ":authority" && len(values) != 0 {
+				r.Host = values[0]
+			}
+			if strings.HasPrefix(key, ":") {
+				continue
+			}
+			for _, value := range values {
+				r.Header.Add(key, value)
+			}
+		}
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			r.RemoteAddr = p.Addr.String()
+		}
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state := info.State
+			r.TLS = &state
+		}
+	}
+
+	return r.WithContext(ctx)
+}
+
+// To encode a message as protojson, cut to the limit.
+// The size is the size of the message on the wire.
+func captureMessage(msg interface{}, limit int64) ([]byte, int64, bool) {
+	m, ok := msg.(proto.Message)
+	if !ok || m == nil {
+		return nil, 0, false
+	}
+
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return nil, int64(proto.Size(m)), false
+	}
+
+	if int64(len(data)) > limit {
+		return data[:limit], int64(proto.Size(m)), true
+	}
+	return data, int64(proto.Size(m)), false
+}
+
+// messageRecorder keeps the messages of a stream as a protojson array.
+// Messages that do not fit in the limit are left out whole, so the array stays valid.
+// Nothing is recorded when the limit is zero.
+type messageRecorder struct {
+	limit     int64
+	buf       bytes.Buffer
+	count     int
+	size      int64
+	truncated bool
+}
+
+func newMessageRecorder(limit int64) *messageRecorder {
+	return &messageRecorder{limit: limit}
+}
+
+func (m *messageRecorder) record(msg interface{}) {
+	if m.limit <= 0 {
+		return
+	}
+
+	data, size, truncated := captureMessage(msg, m.limit)
+	m.size += size
+	if truncated || m.truncated || int64(m.buf.Len()+len(data)+1) > m.limit {
+		m.truncated = true
+		return
+	}
+
+	if m.count != 0 {
+		m.buf.WriteByte(',')
+	}
+	m.buf.Write(data)
+	m.count++
+}
+
+// To get the recorded messages as a JSON array.
+func (m *messageRecorder) json() []byte {
+	if m.limit <= 0 || (m.count == 0 && !m.truncated) {
+		return nil
+	}
+	return append(append([]byte{'['}, m.buf.Bytes()...), ']')
+}
+
+// recordedServerStream records the messages of the stream,
+// and carries the context with the event log to the handler.
+type recordedServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	received *messageRecorder
+	sent     *messageRecorder
+}
+
+func (s *recordedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *recordedServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received.record(m)
+	}
+	return err
+}
+
+func (s *recordedServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent.record(m)
+	}
+	return err
+}
@@ -0,0 +1,247 @@
+package audittrailgrpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	audittrail "github.com/raihansuwanto/audit-trail"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// testEchoService is a hand written gRPC service, so the tests do not need generated code.
+var testEchoService = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := &structpb.Struct{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				in := req.(*structpb.Struct)
+				audittrail.FromContext(ctx).StartAction("echo", "echo the request").Succeed().End()
+
+				if in.Fields["fail"].GetBoolValue() {
+					return nil, status.Error(codes.NotFound, "loan not found")
+				}
+				return in, nil
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Echo/Echo"}, handler)
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Chat",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			audittrail.FromContext(stream.Context()).StartAction("chat", "echo the messages").Succeed().End()
+			for {
+				in := &structpb.Struct{}
+				if err := stream.RecvMsg(in); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := stream.SendMsg(in); err != nil {
+					return err
+				}
+			}
+		},
+	}},
+}
+
+func newTestGRPCClient(t *testing.T, cfg audittrail.ActivityLogConfig) (*grpc.ClientConn, <-chan *message.Message) {
+	publisher := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	messages, _ := publisher.Subscribe(context.Background(), cfg.TopicName)
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(NewUnaryServerInterceptor(publisher, cfg)),
+		grpc.StreamInterceptor(NewStreamServerInterceptor(publisher, cfg)),
+	)
+	server.RegisterService(&testEchoService, struct{}{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Error dialing bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, messages
+}
+
+func receiveTransaction(t *testing.T, messages <-chan *message.Message) *audittrail.Transaction {
+	select {
+	case msg := <-messages:
+		msg.Ack()
+		result := &audittrail.Transaction{}
+		if err := json.Unmarshal(msg.Payload, result); err != nil {
+			t.Fatalf("Error unmarshalling payload: %v", err)
+		}
+		return result
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the activity log to be published")
+		return nil
+	}
+}
+
+func TestNewUnaryServerInterceptor(t *testing.T) {
+	cfg := audittrail.ActivityLogConfig{
+		ServiceName:          "testService",
+		TopicName:            "testTopic",
+		IsRecordRequestBody:  true,
+		IsRecordResponseBody: true,
+		IsRecordHeader:       true,
+		IsRecordResponseCode: true,
+		ActorResolver: audittrail.ActorResolverFunc(func(r *http.Request) (*audittrail.ResolvedActor, error) {
+			return &audittrail.ResolvedActor{ID: r.Header.Get("X-Actor-Id")}, nil
+		}),
+	}
+	conn, messages := newTestGRPCClient(t, cfg)
+
+	t.Run("records the call", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-actor-id", "agent-id", "authorization", "Bearer secret")
+		req, _ := structpb.NewStruct(map[string]interface{}{"loanId": "42"})
+
+		if err := conn.Invoke(ctx, "/test.Echo/Echo", req, &structpb.Struct{}); err != nil {
+			t.Fatalf("Error invoking: %v", err)
+		}
+
+		result := receiveTransaction(t, messages)
+		if result.Target != "/test.Echo/Echo" {
+			t.Errorf("Expected Target to be %s, but got %s", "/test.Echo/Echo", result.Target)
+		}
+		if result.Actor != "agent-id" {
+			t.Errorf("Expected Actor to be %s, but got %s", "agent-id", result.Actor)
+		}
+		if result.RequestBody["loanId"] != "42" || result.ResponseBody["loanId"] != "42" {
+			t.Errorf("Expected the messages to be recorded, but got %v and %v", result.RequestBody, result.ResponseBody)
+		}
+		if result.GRPCStatus == nil || result.GRPCStatus.Code != "OK" || result.ResponseCode != http.StatusOK {
+			t.Errorf("Expected status to be OK with ResponseCode %d, but got %+v and %d", http.StatusOK, result.GRPCStatus, result.ResponseCode)
+		}
+		if auth, _ := result.Header["Authorization"].([]interface{}); len(auth) != 1 || auth[0] != audittrail.MaskedValue {
+			t.Errorf("Expected the authorization metadata to be masked, but got %v", result.Header["Authorization"])
+		}
+	})
+
+	t.Run("records the status of a failed call", func(t *testing.T) {
+		req, _ := structpb.NewStruct(map[string]interface{}{"fail": true})
+
+		err := conn.Invoke(context.Background(), "/test.Echo/Echo", req, &structpb.Struct{})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("Expected error code to be %s, but got %v", codes.NotFound, err)
+		}
+
+		result := receiveTransaction(t, messages)
+		if result.ResponseCode != http.StatusNotFound {
+			t.Errorf("Expected ResponseCode to be %d, but got %d", http.StatusNotFound, result.ResponseCode)
+		}
+		if result.GRPCStatus == nil || result.GRPCStatus.Code != "NotFound" || result.GRPCStatus.Message != "loan not found" {
+			t.Errorf("Expected status to be NotFound, but got %+v", result.GRPCStatus)
+		}
+	})
+}
+
+func TestNewStreamServerInterceptor(t *testing.T) {
+	cfg := audittrail.ActivityLogConfig{
+		ServiceName:          "testService",
+		TopicName:            "testTopic",
+		IsRecordRequestBody:  true,
+		IsRecordResponseBody: true,
+		IsRecordResponseCode: true,
+	}
+	conn, messages := newTestGRPCClient(t, cfg)
+
+	stream, err := conn.NewStream(context.Background(), &testEchoService.Streams[0], "/test.Echo/Chat")
+	if err != nil {
+		t.Fatalf("Error opening stream: %v", err)
+	}
+	for _, id := range []string{"1", "2"} {
+		msg, _ := structpb.NewStruct(map[string]interface{}{"id": id})
+		if err := stream.SendMsg(msg); err != nil {
+			t.Fatalf("Error sending: %v", err)
+		}
+		if err := stream.RecvMsg(&structpb.Struct{}); err != nil {
+			t.Fatalf("Error receiving: %v", err)
+		}
+	}
+	stream.CloseSend()
+	if err := stream.RecvMsg(&structpb.Struct{}); err != io.EOF {
+		t.Fatalf("Expected the stream to end, but got %v", err)
+	}
+
+	result := receiveTransaction(t, messages)
+	if result.Target != "/test.Echo/Chat" {
+		t.Errorf("Expected Target to be %s, but got %s", "/test.Echo/Chat", result.Target)
+	}
+
+	received, _ := result.RequestBodyValue.([]interface{})
+	sent, _ := result.ResponseBodyValue.([]interface{})
+	if len(received) != 2 || len(sent) != 2 {
+		t.Errorf("Expected 2 received and 2 sent messages, but got %v and %v", result.RequestBodyValue, result.ResponseBodyValue)
+	}
+	if len(result.Activities) != 1 {
+		t.Errorf("Expected 1 activity, but got %d", len(result.Activities))
+	}
+}
+
+func TestMessageRecorderKeepsWholeMessages(t *testing.T) {
+	recorder := newMessageRecorder(30)
+	for _, id := range []string{"1", "2", "3"} {
+		msg, _ := structpb.NewStruct(map[string]interface{}{"id": id})
+		recorder.record(msg)
+	}
+
+	var messages []interface{}
+	if err := json.Unmarshal(recorder.json(), &messages); err != nil {
+		t.Fatalf("Expected a valid JSON array, but got %s", recorder.json())
+	}
+	if len(messages) != 2 || !recorder.truncated {
+		t.Errorf("Expected 2 messages and truncated, but got %s", recorder.json())
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		code   codes.Code
+		status int
+	}{
+		{codes.OK, http.StatusOK},
+		{codes.InvalidArgument, http.StatusBadRequest},
+		{codes.Unauthenticated, http.StatusUnauthorized},
+		{codes.PermissionDenied, http.StatusForbidden},
+		{codes.AlreadyExists, http.StatusConflict},
+		{codes.Unavailable, http.StatusServiceUnavailable},
+		{codes.Unknown, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		if status := HTTPStatus(test.code); status != test.status {
+			t.Errorf("Expected ResponseCode of %s to be %d, but got %d", test.code, test.status, status)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Package audittrailmux resolves the route patterns of the gorilla/mux router for the activity log middleware.
+package audittrailmux
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	audittrail "github.com/raihansuwanto/audit-trail"
+)
+
+// RoutePattern creates a RoutePatternResolver for gorilla/mux.
+// The current route is used when the middleware is added with router.Use,
+// otherwise the request is matched against router, which may be nil.
+//
+// Example:
+//
+//	cfg.RoutePatternResolver = audittrailmux.RoutePattern(router)
+//	router.Use(audittrail.NewHTTPMiddleware(publisher, cfg))
+func RoutePattern(router *mux.Router) audittrail.RoutePatternResolver {
+	return func(r *http.Request) string {
+		route := mux.CurrentRoute(r)
+		if route == nil && router != nil {
+			var match mux.RouteMatch
+			if router.Match(r, &match) {
+				route = match.Route
+			}
+		}
+		if route == nil {
+			return ""
+		}
+
+		pattern, err := route.GetPathTemplate()
+		if err != nil {
+			return ""
+		}
+		return pattern
+	}
+}
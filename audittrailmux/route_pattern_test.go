@@ -0,0 +1,50 @@
+package audittrailmux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	audittrail "github.com/raihansuwanto/audit-trail"
+)
+
+func TestRoutePattern(t *testing.T) {
+	var target string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		target = audittrail.FromContext(r.Context()).Target
+	}
+	middleware := func(resolver audittrail.RoutePatternResolver) func(http.Handler) http.Handler {
+		return audittrail.NewHTTPMiddleware(nil, audittrail.ActivityLogConfig{RoutePatternResolver: resolver})
+	}
+
+	router := mux.NewRouter()
+	router.Use(middleware(RoutePattern(nil)))
+	router.HandleFunc("/loans/{id}", handler).Methods("GET")
+
+	wrappedRouter := mux.NewRouter()
+	wrappedRouter.PathPrefix("/loans").Subrouter().HandleFunc("/{id}", handler)
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"router", router},
+		{"wrapped", middleware(RoutePattern(wrappedRouter))(wrappedRouter)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target = ""
+			test.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/loans/42", nil))
+
+			if target != "GET /loans/{id}" {
+				t.Errorf("Expected target to be %s, but got %s", "GET /loans/{id}", target)
+			}
+		})
+	}
+
+	if pattern := RoutePattern(mux.NewRouter())(httptest.NewRequest("GET", "/unknown/42", nil)); pattern != "" {
+		t.Errorf("Expected no pattern for an unknown route, but got %s", pattern)
+	}
+}
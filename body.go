@@ -0,0 +1,306 @@
+package audittrail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// BodyDecoder is used to decode a captured request or response body into structured data.
+// The content type is passed with its parameters, e.g. the multipart boundary.
+type BodyDecoder func(contentType string, body []byte) (interface{}, error)
+
+// RawBody is used to store a body that could not be decoded.
+type RawBody struct {
+
+	// ContentType is used to store the content type of the body.
+	ContentType string `json:"contentType"`
+
+	// Encoding is used to store the encoding of Data, either "text" or "base64".
+	Encoding string `json:"encoding"`
+
+	// Data is used to store the body, cut to maxRawBodyBytes.
+	Data string `json:"data"`
+
+	// Truncated is used to determine whether Data is only a prefix of the body.
+	Truncated bool `json:"truncated"`
+
+	// Size is used to store the number of captured bytes of the body.
+	Size int `json:"size"`
+
+	// Redacted is used to determine whether Data was dropped, since the masking rules could not be applied to it.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// FilePart is used to store a file uploaded with multipart/form-data.
+// The content of the file is never recorded.
+type FilePart struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+
+	// Size is used to store the size of the file in bytes.
+	// For a truncated file it is the declared Content-Length of the part, -1 when unknown.
+	Size int64 `json:"size"`
+
+	// SHA256 is used to store the hash of the file, empty when the file is truncated.
+	SHA256 string `json:"sha256"`
+
+	// Truncated is used to determine whether the file was cut by the capture limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// maxRawBodyBytes is the number of bytes kept for a body that could not be decoded.
+const maxRawBodyBytes = 4 << 10
+
+// bodyDecoder is used to decode a captured body, knowing whether it was cut by the capture limit.
+type bodyDecoder func(contentType string, body []byte, truncated bool) (interface{}, error)
+
+// To ignore the truncation of the body, for decoders that can not use it.
+func (d BodyDecoder) withTruncation() bodyDecoder {
+	return func(contentType string, body []byte, _ bool) (interface{}, error) {
+		return d(contentType, body)
+	}
+}
+
+var (
+	bodyDecodersMu sync.RWMutex
+	bodyDecoders   = map[string]bodyDecoder{
+		"application/json":                  BodyDecoder(DecodeJSONBody).withTruncation(),
+		"application/x-www-form-urlencoded": BodyDecoder(DecodeFormBody).withTruncation(),
+		"multipart/form-data":               decodeMultipartBody,
+	}
+)
+
+// RegisterBodyDecoder registers the decoder of a media type, e.g. "application/xml".
+// It replaces the decoder already registered for the media type.
+func RegisterBodyDecoder(mediaType string, decoder BodyDecoder) {
+	bodyDecodersMu.Lock()
+	defer bodyDecodersMu.Unlock()
+	bodyDecoders[strings.ToLower(mediaType)] = decoder.withTruncation()
+}
+
+// To find the decoder of the content type.
+// Structured syntax suffixes like application/problem+json fall back to their base type.
+func bodyDecoderFor(contentType string) bodyDecoder {
+	mediaType := mediaTypeOf(contentType)
+
+	bodyDecodersMu.RLock()
+	defer bodyDecodersMu.RUnlock()
+
+	if decoder, ok := bodyDecoders[mediaType]; ok {
+		return decoder
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		if decoder, ok := bodyDecoders["application/"+mediaType[i+1:]]; ok {
+			return decoder
+		}
+	}
+	return nil
+}
+
+// To get the lower case media type of the content type, without its parameters.
+func mediaTypeOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mediaType
+}
+
+// To check whether the body of the content type is JSON, e.g. application/problem+json.
+// Bodies without content type are decoded as JSON too, see decodeBody.
+func isJSONContentType(contentType string) bool {
+	mediaType := mediaTypeOf(contentType)
+	return mediaType == "" || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// DecodeJSONBody decodes any JSON value, objects, arrays and scalars alike.
+func DecodeJSONBody(_ string, body []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// DecodeFormBody decodes an application/x-www-form-urlencoded body.
+// Fields with a single value are stored as string, the others as a list.
+func DecodeFormBody(_ string, body []byte) (interface{}, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	return formValues(values), nil
+}
+
+// DecodeMultipartBody decodes a multipart/form-data body.
+// File parts are recorded as FilePart, the content of the file is never kept.
+func DecodeMultipartBody(contentType string, body []byte) (interface{}, error) {
+	return decodeMultipartBody(contentType, body, false)
+}
+
+// To decode a multipart/form-data body.
+// A body cut by the capture limit returns the parts read so far. The file cut in half is marked
+// as truncated, without hash, and the field cut in half is dropped.
+func decodeMultipartBody(contentType string, body []byte, truncated bool) (interface{}, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if params["boundary"] == "" {
+		return nil, errors.New("multipart boundary is missing")
+	}
+
+	fields := make(map[string][]interface{})
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			if len(fields) == 0 {
+				return nil, err
+			}
+			break
+		}
+
+		name := part.FormName()
+		if part.FileName() == "" {
+			value, err := io.ReadAll(part)
+			if err != nil {
+				if !truncated {
+					return nil, err
+				}
+				break
+			}
+			fields[name] = append(fields[name], string(value))
+			continue
+		}
+
+		file := FilePart{
+			FileName:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+		}
+
+		hash := sha256.New()
+		size, err := io.Copy(hash, part)
+		if err != nil {
+			if !truncated {
+				return nil, err
+			}
+			file.Truncated = true
+			file.Size = declaredPartSize(part)
+			fields[name] = append(fields[name], file)
+			break
+		}
+
+		file.Size = size
+		file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		fields[name] = append(fields[name], file)
+	}
+
+	result := make(map[string]interface{}, len(fields))
+	for name, values := range fields {
+		if len(values) == 1 {
+			result[name] = values[0]
+		} else {
+			result[name] = values
+		}
+	}
+	return result, nil
+}
+
+// To get the declared Content-Length of the part, -1 when it is not declared.
+func declaredPartSize(part *multipart.Part) int64 {
+	size, err := strconv.ParseInt(part.Header.Get("Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return -1
+	}
+	return size
+}
+
+func formValues(values url.Values) map[string]interface{} {
+	result := make(map[string]interface{}, len(values))
+	for name, v := range values {
+		if len(v) == 1 {
+			result[name] = v[0]
+		} else {
+			result[name] = v
+		}
+	}
+	return result
+}
+
+// To decode the captured body with the decoder of its content type.
+// Objects are returned as object, other decoded values as value,
+// and bodies that can not be decoded as raw.
+func decodeBody(contentType string, body []byte, truncated bool) (object map[string]interface{}, value interface{}, raw *RawBody) {
+	if len(body) == 0 {
+		return nil, nil, nil
+	}
+
+	decoder := bodyDecoderFor(contentType)
+	if contentType == "" {
+		// Clients often omit the content type of JSON bodies.
+		decoder = BodyDecoder(DecodeJSONBody).withTruncation()
+	}
+
+	if decoder != nil {
+		decoded, err := decoder(contentType, body, truncated)
+		if err == nil {
+			if object, ok := decoded.(map[string]interface{}); ok {
+				return object, nil, nil
+			}
+			return nil, decoded, nil
+		}
+	}
+
+	return nil, nil, newRawBody(contentType, body, truncated)
+}
+
+// To keep the body as a truncated string, or base64 when it is not valid UTF-8.
+func newRawBody(contentType string, body []byte, truncated bool) *RawBody {
+	raw := &RawBody{ContentType: contentType, Truncated: truncated, Size: len(body)}
+
+	if len(body) > maxRawBodyBytes {
+		body = body[:maxRawBodyBytes]
+		raw.Truncated = true
+	}
+
+	if utf8.Valid(body) || (raw.Truncated && utf8.Valid(trimIncompleteRune(body))) {
+		raw.Encoding = "text"
+		raw.Data = string(trimIncompleteRune(body))
+	} else {
+		raw.Encoding = "base64"
+		raw.Data = base64.StdEncoding.EncodeToString(body)
+	}
+	return raw
+}
+
+// To drop a multi-byte character cut in half at the end of the body.
+func trimIncompleteRune(body []byte) []byte {
+	for i := 0; i < utf8.UTFMax && len(body) > 0; i++ {
+		if r, size := utf8.DecodeLastRune(body); r != utf8.RuneError || size != 1 {
+			return body
+		}
+		body = body[:len(body)-1]
+	}
+	return body
+}
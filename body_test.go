@@ -0,0 +1,128 @@
+package audittrail
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	t.Run("decodes top-level JSON arrays and scalars", func(t *testing.T) {
+		object, value, raw := decodeBody("application/problem+json", []byte(`[{"id":1},{"id":2}]`), false)
+
+		if object != nil || raw != nil {
+			t.Errorf("Expected only a value, but got object %v and raw %v", object, raw)
+		}
+
+		if items, ok := value.([]interface{}); !ok || len(items) != 2 {
+			t.Errorf("Expected 2 items, but got %v", value)
+		}
+
+		_, value, _ = decodeBody("application/json", []byte(`"ok"`), false)
+		if value != "ok" {
+			t.Errorf("Expected value to be %s, but got %v", "ok", value)
+		}
+	})
+
+	t.Run("decodes url encoded forms", func(t *testing.T) {
+		object, _, _ := decodeBody("application/x-www-form-urlencoded", []byte("name=Budi&tag=a&tag=b"), false)
+
+		expected := map[string]interface{}{"name": "Budi", "tag": []string{"a", "b"}}
+		if !reflect.DeepEqual(object, expected) {
+			t.Errorf("Expected form to be %v, but got %v", expected, object)
+		}
+	})
+
+	t.Run("decodes multipart forms without keeping file content", func(t *testing.T) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("name", "Budi")
+		file, _ := writer.CreateFormFile("ktp", "ktp.jpg")
+		file.Write([]byte("secret image bytes"))
+		writer.Close()
+
+		object, _, _ := decodeBody(writer.FormDataContentType(), body.Bytes(), false)
+
+		if object["name"] != "Budi" {
+			t.Errorf("Expected name to be %s, but got %v", "Budi", object["name"])
+		}
+
+		part, ok := object["ktp"].(FilePart)
+		if !ok {
+			t.Fatalf("Expected ktp to be a FilePart, but got %v", object["ktp"])
+		}
+
+		if part.FileName != "ktp.jpg" || part.Size != int64(len("secret image bytes")) || len(part.SHA256) != 64 {
+			t.Errorf("Expected file metadata to be recorded, but got %+v", part)
+		}
+
+		payload, _ := json.Marshal(object)
+		if strings.Contains(string(payload), "secret image bytes") {
+			t.Errorf("Expected file content not to be recorded, but got %s", payload)
+		}
+	})
+
+	t.Run("marks files cut by the capture limit as truncated", func(t *testing.T) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("name", "Budi")
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="ktp"; filename="ktp.jpg"`)
+		header.Set("Content-Length", "4096")
+		file, _ := writer.CreatePart(header)
+		file.Write(bytes.Repeat([]byte("x"), 4096))
+		writer.Close()
+
+		object, _, _ := decodeBody(writer.FormDataContentType(), body.Bytes()[:1024], true)
+
+		part, ok := object["ktp"].(FilePart)
+		if !ok {
+			t.Fatalf("Expected ktp to be a FilePart, but got %v", object["ktp"])
+		}
+		expected := FilePart{FileName: "ktp.jpg", Size: 4096, Truncated: true}
+		if !reflect.DeepEqual(part, expected) {
+			t.Errorf("Expected file to be %+v, but got %+v", expected, part)
+		}
+		if object["name"] != "Budi" {
+			t.Errorf("Expected name to be %s, but got %v", "Budi", object["name"])
+		}
+
+		_, _, raw := decodeBody(writer.FormDataContentType(), body.Bytes()[:1024], false)
+		if raw == nil {
+			t.Errorf("Expected a cut body that is not truncated to be kept as raw")
+		}
+	})
+
+	t.Run("keeps bodies without decoder as raw", func(t *testing.T) {
+		_, _, raw := decodeBody("application/xml", []byte("<loan><id>1</id></loan>"), false)
+		expected := &RawBody{ContentType: "application/xml", Encoding: "text", Data: "<loan><id>1</id></loan>", Size: 23}
+		if !reflect.DeepEqual(raw, expected) {
+			t.Errorf("Expected raw to be %+v, but got %+v", expected, raw)
+		}
+
+		_, _, raw = decodeBody("application/octet-stream", []byte{0xff, 0xfe, 0x00}, false)
+		if raw.Encoding != "base64" || raw.Data != "//4A" {
+			t.Errorf("Expected raw to be base64 encoded, but got %+v", raw)
+		}
+
+		_, _, raw = decodeBody("text/plain", bytes.Repeat([]byte("a"), maxRawBodyBytes+1), false)
+		if len(raw.Data) != maxRawBodyBytes || !raw.Truncated {
+			t.Errorf("Expected raw to be truncated to %d bytes, but got %d", maxRawBodyBytes, len(raw.Data))
+		}
+	})
+
+	t.Run("uses registered decoders", func(t *testing.T) {
+		RegisterBodyDecoder("application/vnd.test", func(contentType string, body []byte) (interface{}, error) {
+			return map[string]interface{}{"length": len(body)}, nil
+		})
+
+		object, _, _ := decodeBody("application/vnd.test; charset=utf-8", []byte("abc"), false)
+		if object["length"] != 3 {
+			t.Errorf("Expected length to be %d, but got %v", 3, object["length"])
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package audittrail
+
+import (
+	"bytes"
+	"io"
+)
+
+// DefaultMaxBodyBytes is the number of body bytes kept for the event log
+// when MaxRequestBodyBytes or MaxResponseBodyBytes is not configured.
+const DefaultMaxBodyBytes int64 = 64 << 10
+
+// bodyCapture passes the request body through to the handler untouched,
+// and keeps only the first bytes of it for the event log.
+type bodyCapture struct {
+	io.ReadCloser
+	limit int64
+	buf   bytes.Buffer
+	size  int64
+	eof   bool
+}
+
+func newBodyCapture(body io.ReadCloser, limit int64) *bodyCapture {
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	return &bodyCapture{ReadCloser: body, limit: limit}
+}
+
+func (c *bodyCapture) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.size += int64(n)
+
+	if remaining := c.limit - int64(c.buf.Len()); remaining > 0 && n > 0 {
+		if int64(n) < remaining {
+			remaining = int64(n)
+		}
+		c.buf.Write(p[:remaining])
+	}
+
+	if err == io.EOF {
+		c.eof = true
+	}
+	return n, err
+}
+
+// To read the rest of the prefix the handler did not read, e.g. when it rejected the request early.
+// One more byte is read, so a body longer than the limit is known to be truncated.
+func (c *bodyCapture) fill() {
+	if c.eof {
+		return
+	}
+	remaining := c.limit - int64(c.buf.Len())
+	if remaining < 0 {
+		remaining = 0
+	}
+	io.Copy(io.Discard, io.LimitReader(c, remaining+1))
+}
+
+// To get the captured prefix of the body.
+func (c *bodyCapture) bytes() []byte {
+	return c.buf.Bytes()
+}
+
+// To get the original size of the body.
+// When the handler did not read the whole body, the declared content length is used.
+func (c *bodyCapture) originalSize(contentLength int64) int64 {
+	if !c.eof && contentLength > c.size {
+		return contentLength
+	}
+	return c.size
+}
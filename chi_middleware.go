@@ -1,119 +1,113 @@
 package audittrail
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"bitbucket.org/tunaiku/amargo-core/pkg/logger"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/render"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
-type responseWriter struct {
-	http.ResponseWriter
-	statusCode int
-	body       bytes.Buffer
-}
+// NewActivityLogMiddleware creates the activity log middleware for chi.
+// The route pattern is resolved with ChiRoutePattern, unless cfg.RoutePatternResolver is set.
+func NewActivityLogMiddleware(publisher message.Publisher, cfg ActivityLogConfig) chi.Middlewares {
+	if cfg.RoutePatternResolver == nil {
+		cfg.RoutePatternResolver = ChiRoutePattern
+	}
 
-func (rw *responseWriter) WriteHeader(statusCode int) {
-	rw.statusCode = statusCode
-	rw.ResponseWriter.WriteHeader(statusCode)
+	return chi.Middlewares{NewHTTPMiddleware(publisher, cfg)}
 }
 
-func (rw *responseWriter) Write(body []byte) (int, error) {
-	rw.body.Write(body)
-	return rw.ResponseWriter.Write(body)
+// To create an event log recorded with the config.
+// The sanitizer, the patch format and the error stacks of the config apply to its activities.
+func newTransaction(cfg ActivityLogConfig, sanitizer *sanitizer) *Transaction {
+	return &Transaction{
+		Service:    cfg.ServiceName,
+		ActorType:  cfg.ActorType,
+		ActorEmail: cfg.ActorEmail,
+
+		sanitizer:          sanitizer,
+		patchFormat:        cfg.PatchFormat,
+		isRecordErrorStack: cfg.IsRecordErrorStack,
+	}
 }
 
-func NewActivityLogMiddleware(publisher message.Publisher, cfg ActivityLogConfig) chi.Middlewares {
-	return chi.Middlewares{
-		func(next http.Handler) http.Handler {
-			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-				ctx := r.Context()
-
-				// Read the body
-				bodyBytes, err := io.ReadAll(r.Body)
-				if err != nil {
-					http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-					return
-				}
-
-				// Restore the body so it can be read again
-				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-				log := createTransactionLog(cfg, r)
-
-				log.Start()
-
-				// Restore the body again for the next handler
-				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-
-				ctx = NewContext(ctx, log)
+func createTransactionLog(cfg ActivityLogConfig, sanitizer *sanitizer, headers *headerRedactor, proxies trustedProxies, r *http.Request, pattern string) *Transaction {
+	log := newTransaction(cfg, sanitizer)
+	log.Target = fmt.Sprintf("%s %s", r.Method, pattern)
+	log.RequestContentLength = r.ContentLength
 
-				rw := &responseWriter{ResponseWriter: w}
-				next.ServeHTTP(rw, r.WithContext(ctx))
-
-				updateLogWithResponse(cfg, rw, log)
-
-				log.End()
+	if cfg.IsRecordHeader {
+		log.Header = headers.redact(r.Header)
+	}
 
-				if len(log.Activities) != 0 || cfg.IsPublishWhenNoActivities {
-					PublishLog(ctx, publisher, log, cfg.TopicName)
-				}
+	resolveActor(cfg.ActorResolver, r, log)
+	resolveDelegationHeaders(cfg.DelegationHeaders, proxies, r, log)
 
-			})
-		},
-	}
+	return log
 }
 
-func createTransactionLog(cfg ActivityLogConfig, r *http.Request) *Transaction {
-	log := &Transaction{
-		Service:   cfg.ServiceName,
-		ActorType: cfg.ActorType,
-		Target:    fmt.Sprintf("%s %s", r.Method, getRoutePattern(r)),
+// To get the number of request body bytes kept for the event log.
+func requestBodyLimit(cfg ActivityLogConfig) int64 {
+	if cfg.MaxRequestBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
 	}
+	return cfg.MaxRequestBodyBytes
+}
 
-	if cfg.IsRecordRequestBody {
-		log.RequestBody = parseBody(r)
+// To get the number of response body bytes kept for the event log, zero when it is not recorded.
+func responseBodyLimit(cfg ActivityLogConfig) int64 {
+	if !cfg.IsRecordResponseBody {
+		return 0
 	}
-
-	if cfg.IsRecordHeader {
-		log.Header = parseHeader(r)
+	if cfg.MaxResponseBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
 	}
+	return cfg.MaxResponseBodyBytes
+}
 
-	return log
+func updateLogWithRequestBody(capture *bodyCapture, r *http.Request, log *Transaction) {
+	log.RequestBodySize = capture.originalSize(r.ContentLength)
+	log.RequestBodyTruncated = log.RequestBodySize > int64(len(capture.bytes()))
+	log.RequestBody, log.RequestBodyValue, log.RequestBodyRaw = parseBody(r.Header.Get("Content-Type"), capture.bytes(), log.RequestBodyTruncated)
 }
 
 func updateLogWithResponse(cfg ActivityLogConfig, r *responseWriter, log *Transaction) {
-	if cfg.IsRecordResponseBody {
-		body := make(map[string]interface{})
-		json.Unmarshal(r.body.Bytes(), &body)
-		log.ResponseBody = body
-	}
+	log.ResponseBodySize = r.size
+	log.ResponseStreamed = r.streaming
+	log.ResponseContentType = r.Header().Get("Content-Type")
 
-	if cfg.IsRecordResponseCode {
-		log.ResponseCode = r.statusCode
+	if cfg.IsRecordResponseBody && !r.streaming {
+		log.ResponseBody, log.ResponseBodyValue, log.ResponseBodyRaw = decodeBody(log.ResponseContentType, r.body.Bytes(), r.truncated)
+		log.ResponseBodyTruncated = r.truncated
 	}
+
 }
 
 func PublishLog(ctx context.Context, publisher message.Publisher, log *Transaction, topicName string) {
+	if publisher == nil {
+		logger.IWithTraceId(ctx).Error("activity log is not published without a publisher ", logrus.Fields{
+			"topic": topicName,
+		})
+		return
+	}
+
 	log.EventID = uuid.New().String()
+	payload := log.GetPayloadTransaction()
 
 	logger.IWithTraceId(ctx).Debug("publishing activity log ", logrus.Fields{
-		"activityLog": fmt.Sprintf("%+v", *log),
+		"activityLog": string(payload),
 		"logID":       log.EventID,
 	})
 
-	msg := message.NewMessage(log.EventID, log.GetPayloadTransaction())
+	msg := message.NewMessage(log.EventID, payload)
 	err := publisher.Publish(topicName, msg)
 	if err != nil {
 		logger.IWithTraceId(ctx).Error("error publishing activity log ", logrus.Fields{
@@ -122,19 +116,8 @@ func PublishLog(ctx context.Context, publisher message.Publisher, log *Transacti
 	}
 }
 
-func parseHeader(r *http.Request) map[string]interface{} {
-	header := make(map[string]interface{})
-	for k, v := range r.Header {
-		header[k] = v
-	}
-	return header
-}
-
-func parseBody(r *http.Request) map[string]interface{} {
-	body := make(map[string]interface{})
-	if err := render.DecodeJSON(r.Body, &body); err != nil {
-		return nil
-	}
+func parseBody(contentType string, data []byte, truncated bool) (map[string]interface{}, interface{}, *RawBody) {
+	body, value, raw := decodeBody(contentType, data, truncated)
 
 	// Check for large data and remove it
 	for key, value := range body {
@@ -144,7 +127,7 @@ func parseBody(r *http.Request) map[string]interface{} {
 		}
 	}
 
-	return body
+	return body, value, raw
 }
 
 func parseResponse(r *httptest.ResponseRecorder) map[string]interface{} {
@@ -153,37 +136,25 @@ func parseResponse(r *httptest.ResponseRecorder) map[string]interface{} {
 	return body
 }
 
-func getRoutePattern(r *http.Request) string {
-	rctx := chi.RouteContext(r.Context())
+func ProcessVendorActivityLog(ctx context.Context, log *Transaction, publisher message.Publisher, cfg ActivityLogConfig) {
+	ctx = NewContext(ctx, log)
 
-	if pattern := rctx.RoutePattern(); pattern != "" {
-		// Pattern is already available
-		return pattern
+	if log.sanitizer == nil {
+		log.sanitizer = newSanitizer(cfg)
 	}
-	routePath := r.URL.Path
-
-	if r.URL.RawPath != "" {
-		routePath = r.URL.RawPath
+	if log.patchFormat == "" {
+		log.patchFormat = cfg.PatchFormat
 	}
-
-	tctx := chi.NewRouteContext()
-
-	if !rctx.Routes.Match(tctx, r.Method, routePath) {
-		// No matching pattern, so just return the request path.
-		// Depending on your use case, it might make sense to
-		// return an empty string or error here instead
-		return routePath
+	if cfg.IsRecordErrorStack {
+		log.isRecordErrorStack = true
 	}
 
-	// tctx has the updated pattern, since Match mutates it
-	return tctx.RoutePattern()
-
-}
-
-func ProcessVendorActivityLog(ctx context.Context, log *Transaction, publisher message.Publisher, cfg ActivityLogConfig) {
-	ctx = NewContext(ctx, log)
-
 	log.End()
 
 	PublishLog(ctx, publisher, log, cfg.TopicName)
 }
+
+// To convert the duration to milliseconds, keeping sub-millisecond precision.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
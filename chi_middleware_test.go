@@ -5,10 +5,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
@@ -151,3 +155,243 @@ func TestNewActivityLogMiddleware(t *testing.T) {
 	}
 
 }
+
+func TestNewActivityLogMiddlewareTruncatesRequestBody(t *testing.T) {
+	publisher := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	cfg := ActivityLogConfig{
+		ServiceName:               "testService",
+		IsRecordRequestBody:       true,
+		IsPublishWhenNoActivities: true,
+		MaxRequestBodyBytes:       16,
+		TopicName:                 "testTopic",
+	}
+
+	subscriber, _ := publisher.Subscribe(context.Background(), cfg.TopicName)
+
+	reqBodyBytes, _ := json.Marshal(map[string]string{"message": "a message longer than the limit"})
+
+	r := chi.NewRouter()
+	r.Use(NewActivityLogMiddleware(publisher, cfg)...)
+	r.Post("/test", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Error reading request body: %v", err)
+		}
+
+		if !bytes.Equal(body, reqBodyBytes) {
+			t.Errorf("Expected handler to receive %s, but got %s", reqBodyBytes, body)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest("POST", "/test", bytes.NewBuffer(reqBodyBytes))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	msgs := <-subscriber
+
+	var result Transaction
+	json.Unmarshal(msgs.Payload, &result)
+
+	if !result.RequestBodyTruncated {
+		t.Errorf("Expected RequestBodyTruncated to be true, but got false")
+	}
+
+	if result.RequestBodySize != int64(len(reqBodyBytes)) {
+		t.Errorf("Expected RequestBodySize to be %d, but got %d", len(reqBodyBytes), result.RequestBodySize)
+	}
+
+	if result.RequestBodyRaw == nil || result.RequestBodyRaw.Data != string(reqBodyBytes[:16]) || !result.RequestBodyRaw.Truncated {
+		t.Errorf("Expected RequestBodyRaw to keep the first %d bytes, but got %+v", 16, result.RequestBodyRaw)
+	}
+}
+
+func TestNewActivityLogMiddlewareRecordsUnreadRequestBody(t *testing.T) {
+	publisher := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	cfg := ActivityLogConfig{
+		ServiceName:               "testService",
+		IsRecordRequestBody:       true,
+		IsPublishWhenNoActivities: true,
+		TopicName:                 "testTopic",
+	}
+
+	subscriber, _ := publisher.Subscribe(context.Background(), cfg.TopicName)
+
+	r := chi.NewRouter()
+	r.Use(NewActivityLogMiddleware(publisher, cfg)...)
+	r.Post("/test", func(w http.ResponseWriter, r *http.Request) {
+		// Reject the request before reading the body
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	req := httptest.NewRequest("POST", "/test", strings.NewReader(`{"nik":"3171234567890001"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	msgs := <-subscriber
+
+	var result Transaction
+	json.Unmarshal(msgs.Payload, &result)
+
+	if result.RequestBody["nik"] != "3171234567890001" {
+		t.Errorf("Expected the unread body to be recorded, but got %v", result.RequestBody)
+	}
+	if result.RequestBodyTruncated {
+		t.Errorf("Expected RequestBodyTruncated to be false, but got true")
+	}
+}
+
+func TestNewActivityLogMiddlewareRecordsResponseMetadata(t *testing.T) {
+	publisher := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	cfg := ActivityLogConfig{
+		ServiceName:               "testService",
+		IsRecordResponseCode:      true,
+		IsPublishWhenNoActivities: true,
+		TopicName:                 "testTopic",
+	}
+
+	subscriber, _ := publisher.Subscribe(context.Background(), cfg.TopicName)
+
+	r := chi.NewRouter()
+	r.Use(NewActivityLogMiddleware(publisher, cfg)...)
+	r.Post("/test", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("created"))
+	})
+
+	req := httptest.NewRequest("POST", "/test", bytes.NewBufferString("hello"))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	msgs := <-subscriber
+
+	var result Transaction
+	json.Unmarshal(msgs.Payload, &result)
+
+	if result.ResponseCode != http.StatusOK {
+		t.Errorf("Expected ResponseCode to be %d, but got %d", http.StatusOK, result.ResponseCode)
+	}
+
+	if result.ResponseBodySize != int64(len("created")) {
+		t.Errorf("Expected ResponseBodySize to be %d, but got %d", len("created"), result.ResponseBodySize)
+	}
+
+	if result.ResponseContentType != "text/plain" {
+		t.Errorf("Expected ResponseContentType to be %s, but got %s", "text/plain", result.ResponseContentType)
+	}
+
+	if result.RequestContentLength != int64(len("hello")) {
+		t.Errorf("Expected RequestContentLength to be %d, but got %d", len("hello"), result.RequestContentLength)
+	}
+
+	if result.HandlerLatencyMs < 1 || result.TotalLatencyMs < result.HandlerLatencyMs {
+		t.Errorf("Expected latencies to be measured, but got handler %f and total %f", result.HandlerLatencyMs, result.TotalLatencyMs)
+	}
+}
+
+func TestNewActivityLogMiddlewareMeasuresHandlerApartFromActorResolution(t *testing.T) {
+	publisher := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	cfg := ActivityLogConfig{
+		ServiceName:               "testService",
+		IsPublishWhenNoActivities: true,
+		TopicName:                 "testTopic",
+		ActorResolver: ActorResolverFunc(func(r *http.Request) (*ResolvedActor, error) {
+			time.Sleep(20 * time.Millisecond)
+			return nil, nil
+		}),
+	}
+
+	subscriber, _ := publisher.Subscribe(context.Background(), cfg.TopicName)
+
+	r := chi.NewRouter()
+	r.Use(NewActivityLogMiddleware(publisher, cfg)...)
+	r.Get("/test", func(w http.ResponseWriter, r *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+
+	msgs := <-subscriber
+
+	var result Transaction
+	json.Unmarshal(msgs.Payload, &result)
+
+	if result.TotalLatencyMs < 20 {
+		t.Errorf("Expected TotalLatencyMs to include the actor resolution, but got %f", result.TotalLatencyMs)
+	}
+
+	if result.HandlerLatencyMs >= 20 {
+		t.Errorf("Expected HandlerLatencyMs to exclude the actor resolution, but got %f", result.HandlerLatencyMs)
+	}
+}
+
+func TestNewActivityLogMiddlewareRecordsPanic(t *testing.T) {
+	publisher := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	cfg := ActivityLogConfig{
+		ServiceName: "testService",
+		TopicName:   "testTopic",
+	}
+
+	subscriber, _ := publisher.Subscribe(context.Background(), cfg.TopicName)
+
+	var upstreamRecovered interface{}
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				upstreamRecovered = recover()
+				w.WriteHeader(http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Use(NewActivityLogMiddleware(publisher, cfg)...)
+	r.Post("/test", func(w http.ResponseWriter, r *http.Request) {
+		panic("ledger is unavailable")
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/test", nil))
+
+	if upstreamRecovered != "ledger is unavailable" {
+		t.Errorf("Expected the panic to reach the upstream recoverer, but got %v", upstreamRecovered)
+	}
+
+	msgs := <-subscriber
+
+	var result Transaction
+	json.Unmarshal(msgs.Payload, &result)
+
+	if result.Status != TransactionStatusFailed {
+		t.Errorf("Expected Status to be %s, but got %s", TransactionStatusFailed, result.Status)
+	}
+
+	if result.ResponseCode != http.StatusInternalServerError {
+		t.Errorf("Expected ResponseCode to be %d, but got %d", http.StatusInternalServerError, result.ResponseCode)
+	}
+
+	if result.Error == nil {
+		t.Fatalf("Expected Error to be set, but it was not")
+	}
+
+	if result.Error.Message != "ledger is unavailable" || result.Error.Type != "string" || !result.Error.IsPanic {
+		t.Errorf("Expected Error to describe the panic, but got %+v", result.Error)
+	}
+
+	if !strings.Contains(result.Error.Stack, "TestNewActivityLogMiddlewareRecordsPanic") {
+		t.Errorf("Expected Stack to contain the panicking handler, but got %s", result.Error.Stack)
+	}
+}
+
+func receiveTransaction(t *testing.T, messages <-chan *message.Message) *Transaction {
+	select {
+	case msg := <-messages:
+		msg.Ack()
+		result := &Transaction{}
+		if err := json.Unmarshal(msg.Payload, result); err != nil {
+			t.Fatalf("Error unmarshalling payload: %v", err)
+		}
+		return result
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the activity log to be published")
+		return nil
+	}
+}
@@ -0,0 +1,177 @@
+package audittrail
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientInfo is used to store where the request came from.
+type ClientInfo struct {
+
+	// IP is used to store the IP address of the client.
+	// Forwarding headers are only trusted when they are set by a trusted proxy.
+	// It is "unknown" when the hop before the trusted proxies is unknown or obfuscated, e.g. "for=_hidden".
+	IP string `json:"ip"`
+
+	// RemoteAddr is used to store the address of the peer of the connection.
+	RemoteAddr string `json:"remoteAddr"`
+
+	// UserAgent is used to store the user agent of the client.
+	UserAgent string `json:"userAgent"`
+
+	// TLSVersion is used to store the TLS version of the connection, e.g. "TLS 1.3".
+	TLSVersion string `json:"tlsVersion,omitempty"`
+
+	// TLSCipher is used to store the TLS cipher suite of the connection.
+	TLSCipher string `json:"tlsCipher,omitempty"`
+}
+
+// unknownClientIP is the client IP when the forwarding headers hide it.
+const unknownClientIP = "unknown"
+
+// DefaultForwardedHeader is the header the trusted proxies set the client address in, unless ForwardedHeader is set.
+const DefaultForwardedHeader = "X-Forwarded-For"
+
+// To get the one forwarding header of the trusted proxies, see ActivityLogConfig.ForwardedHeader.
+func forwardedHeader(cfg ActivityLogConfig) string {
+	if cfg.ForwardedHeader == "" {
+		return DefaultForwardedHeader
+	}
+	return http.CanonicalHeaderKey(cfg.ForwardedHeader)
+}
+
+type trustedProxies []netip.Prefix
+
+// To parse the trusted proxies of the config, either CIDRs or single IP addresses.
+// It panics when an entry is invalid, so misconfiguration is caught on startup.
+func newTrustedProxies(entries []string) trustedProxies {
+	proxies := make(trustedProxies, 0, len(entries))
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			panic(fmt.Sprintf("audittrail: invalid trusted proxy %q", entry))
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return proxies
+}
+
+func (p trustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// To capture the network identity of the client, header is the forwarding header of the trusted proxies.
+func (p trustedProxies) clientInfo(r *http.Request, header string) *ClientInfo {
+	info := &ClientInfo{
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		IP:         p.clientIP(r, header),
+	}
+
+	if r.TLS != nil {
+		info.TLSVersion = tls.VersionName(r.TLS.Version)
+		info.TLSCipher = tls.CipherSuiteName(r.TLS.CipherSuite)
+	}
+
+	return info
+}
+
+// To resolve the client IP.
+// Only the forwarding header of the trusted proxies is read, since clients can send the others through them.
+// It is walked from the closest hop, and the first hop that is not a trusted proxy is the client.
+func (p trustedProxies) clientIP(r *http.Request, header string) string {
+	remote, ok := parseHostAddr(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+
+	if !p.contains(remote) {
+		return remote.String()
+	}
+
+	hops := forwardedHops(r.Header, header)
+	if len(hops) == 0 {
+		return remote.String()
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHostAddr(hops[i])
+		if !ok {
+			// Unknown or obfuscated hops can not be followed any further, and hide the client.
+			return unknownClientIP
+		}
+		client = hop
+		if !p.contains(hop) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// To get the forwarded hops from the forwarding header name, e.g. X-Forwarded-For, Forwarded or X-Real-IP.
+func forwardedHops(header http.Header, name string) []string {
+	var hops []string
+
+	switch http.CanonicalHeaderKey(name) {
+	case "Forwarded":
+		for _, value := range header.Values(name) {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+					if found && strings.EqualFold(key, "for") {
+						hops = append(hops, strings.Trim(val, `"`))
+					}
+				}
+			}
+		}
+		return hops
+	case "X-Real-Ip":
+		if value := strings.TrimSpace(header.Get(name)); value != "" {
+			hops = append(hops, value)
+		}
+		return hops
+	}
+
+	for _, value := range header.Values(name) {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// To parse an address with an optional port, e.g. "10.0.0.1", "10.0.0.1:443" or "[2001:db8::1]:443".
+func parseHostAddr(value string) (netip.Addr, bool) {
+	if value == "" {
+		return netip.Addr{}, false
+	}
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
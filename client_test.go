@@ -0,0 +1,73 @@
+package audittrail
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies := newTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"})
+
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"untrusted peer ignores forwarding headers", DefaultForwardedHeader, "203.0.113.9:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.9"},
+		{"trusted peer uses the first untrusted hop", DefaultForwardedHeader, "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"all hops trusted uses the leftmost hop", DefaultForwardedHeader, "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "192.168.1.1, 10.0.0.2"}, "192.168.1.1"},
+		{"client supplied forwarded header is ignored", DefaultForwardedHeader, "192.168.1.1:5000", map[string]string{"Forwarded": "for=198.51.100.7", "X-Forwarded-For": "1.1.1.1"}, "1.1.1.1"},
+		{"client supplied x-real-ip is ignored", DefaultForwardedHeader, "10.0.0.1:5000", map[string]string{"X-Real-IP": "198.51.100.3"}, "10.0.0.1"},
+		{"forwarded header of the proxies", "Forwarded", "192.168.1.1:5000", map[string]string{"Forwarded": `for=198.51.100.7;proto=https, for="[2001:db8::1]:4711"`, "X-Forwarded-For": "1.1.1.1"}, "198.51.100.7"},
+		{"obfuscated hops hide the client", "Forwarded", "10.0.0.1:5000", map[string]string{"Forwarded": "for=198.51.100.7, for=_hidden, for=10.0.0.2"}, "unknown"},
+		{"unknown hops hide the client", "Forwarded", "10.0.0.1:5000", map[string]string{"Forwarded": "for=unknown"}, "unknown"},
+		{"x-real-ip from a trusted peer", "X-Real-IP", "10.0.0.1:5000", map[string]string{"X-Real-IP": "198.51.100.3", "X-Forwarded-For": "1.1.1.1"}, "198.51.100.3"},
+		{"ipv6 peer", DefaultForwardedHeader, "[2001:db9::1]:5000", nil, "2001:db9::1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			for k, v := range test.headers {
+				req.Header.Set(k, v)
+			}
+
+			if ip := proxies.clientIP(req, test.header); ip != test.expected {
+				t.Errorf("Expected client IP to be %s, but got %s", test.expected, ip)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesClientInfo(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.9:5000"
+	req.Header.Set("User-Agent", "loan-app/1.0")
+	req.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256}
+
+	info := newTrustedProxies(nil).clientInfo(req, DefaultForwardedHeader)
+
+	expected := ClientInfo{
+		IP:         "203.0.113.9",
+		RemoteAddr: "203.0.113.9:5000",
+		UserAgent:  "loan-app/1.0",
+		TLSVersion: "TLS 1.3",
+		TLSCipher:  "TLS_AES_128_GCM_SHA256",
+	}
+	if *info != expected {
+		t.Errorf("Expected client info to be %+v, but got %+v", expected, *info)
+	}
+}
+
+func TestNewTrustedProxiesPanicsOnInvalidEntry(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected an invalid trusted proxy to panic")
+		}
+	}()
+	newTrustedProxies([]string{"not-an-ip"})
+}
@@ -10,4 +10,80 @@ type ActivityLogConfig struct {
 	IsRecordHeader            bool
 	IsRecordResponseCode      bool
 	IsPublishWhenNoActivities bool
+
+	// HeaderAllowlist is used to limit the recorded headers to the listed names.
+	// The names are matched case-insensitively. When empty, every header is recorded.
+	HeaderAllowlist []string
+
+	// HeaderDenylist is used to list the headers that must be masked.
+	// It is applied on top of DefaultSensitiveHeaders, and also to allowlisted headers.
+	HeaderDenylist []string
+
+	// HeaderMaskMode is used to determine how denylisted headers are masked.
+	// Defaults to HeaderMaskModeReplace.
+	HeaderMaskMode HeaderMaskMode
+
+	// HeaderHashKey is used as the key of the HeaderMaskModeHash fingerprint.
+	// When empty, a random key is generated for the lifetime of the process.
+	HeaderHashKey []byte
+
+	// MaskingRules is used to mask values of the request body, response body, header
+	// and activity data before the event log is published.
+	MaskingRules []MaskingRule
+
+	// MaskingHashKey is used as the key of MaskStrategyHash and of the default Tokenizer.
+	// When empty, a random key is generated for the lifetime of the process.
+	MaskingHashKey []byte
+
+	// Tokenizer is used to issue the tokens of MaskStrategyTokenize.
+	// Defaults to a deterministic, irreversible HMAC token.
+	Tokenizer Tokenizer
+
+	// IsDetectPII is used to scan every string of the request body, response body
+	// and activity data for personal data, and mask it before the event log is published.
+	IsDetectPII bool
+
+	// PIIDetectors is used to store the detectors used when IsDetectPII is set.
+	// Defaults to DefaultPIIDetectors.
+	PIIDetectors []PIIDetector
+
+	// MaxRequestBodyBytes is used to limit the number of request body bytes kept for the event log.
+	// The handler always receives the whole body. Defaults to DefaultMaxBodyBytes.
+	MaxRequestBodyBytes int64
+
+	// MaxResponseBodyBytes is used to limit the number of response body bytes kept for the event log.
+	// The client always receives the whole body. Defaults to DefaultMaxBodyBytes.
+	MaxResponseBodyBytes int64
+
+	// RoutePolicies is used to override the config per route, matched by method and chi route pattern.
+	RoutePolicies []RoutePolicy
+
+	// RoutePatternResolver is used to resolve the route pattern of the request,
+	// e.g. audittrailchi.RoutePattern or audittrailmux.RoutePattern(router).
+	// Defaults to ServeMuxRoutePattern(nil), and to ChiRoutePattern for NewActivityLogMiddleware.
+	RoutePatternResolver RoutePatternResolver
+
+	// TrustedProxies is used to list the CIDRs or IP addresses of the proxies in front of the service.
+	// The ForwardedHeader is only trusted when set by these proxies.
+	TrustedProxies []string
+
+	// ForwardedHeader is used to name the one header the TrustedProxies set the client address in,
+	// X-Forwarded-For when empty. Forwarded and X-Real-IP are supported too.
+	// The other forwarding headers are ignored, since clients can send them through the proxies.
+	ForwardedHeader string
+
+	// ActorResolver is used to fill the actor of every event log from the request,
+	// e.g. with NewJWTActorResolver. Handlers can still override it with SetActor.
+	ActorResolver ActorResolver
+
+	// DelegationHeaders is used to read the impersonated subject of every event log from the request headers.
+	// The headers are not read when nil. They are only accepted from TrustedProxies, or when authorized, see DelegationHeaders.
+	DelegationHeaders *DelegationHeaders
+
+	// PatchFormat is used to record the change of the activities with both data before and after
+	// as a JSON Patch, a Merge Patch or both. Segments can override it with DiffOptions.
+	PatchFormat PatchFormat
+
+	// IsRecordErrorStack is used to determine whether the stack is recorded when an activity fails, see Segment.Fail.
+	IsRecordErrorStack bool
 }
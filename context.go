@@ -19,3 +19,48 @@ func FromContext(ctx context.Context) *Transaction {
 	h, _ := ctx.Value(ActivityLogCtx).(*Transaction)
 	return h
 }
+
+// segmentCtx is used to store the innermost segment of the context, see StartActionCtx.
+const segmentCtx contextKey = "activity_log_segment"
+
+// To start a new action log, and set it to the returned context.
+// The action log is a child of the segment of the context, so action logs started deeper
+// in the call stack with the returned context are nested in it.
+// Without an event log in the context, the segment is not appended to anything.
+//
+// Example:
+//
+//	ctx, activity := activitylog.StartActionCtx(ctx, "approve", "approve loan")
+//	defer activity.End()
+//
+//	// ... function code here, e.g. s.checkLimit(ctx, loan) ...
+//
+//	activity.Succeed()
+func StartActionCtx(ctx context.Context, action string, message string) (context.Context, *Segment) {
+	segment := startSegmentCtx(ctx, action, message)
+	return context.WithValue(ctx, segmentCtx, segment), segment
+}
+
+// Get the innermost segment from the context
+func SegmentFromContext(ctx context.Context) *Segment {
+	if nil == ctx {
+		return nil
+	}
+	segment, _ := ctx.Value(segmentCtx).(*Segment)
+	if segment == nil || segment.root != FromContext(ctx) {
+		return nil
+	}
+	return segment
+}
+
+// To start a new action log as a child of the innermost segment of the context,
+// or as a top level action log of the event log of the context.
+func startSegmentCtx(ctx context.Context, action string, message string) *Segment {
+	if parent := SegmentFromContext(ctx); parent != nil {
+		return parent.StartChild(action, message)
+	}
+	if root := FromContext(ctx); root != nil {
+		return root.StartAction(action, message)
+	}
+	return newDetachedSegment(action, message)
+}
@@ -0,0 +1,88 @@
+package audittrail
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestStartActionCtx(t *testing.T) {
+	log := &Transaction{}
+	ctx := NewContext(context.Background(), log)
+
+	approveCtx, approve := StartActionCtx(ctx, "approve", "approve loan")
+	if SegmentFromContext(approveCtx) != approve {
+		t.Errorf("Expected the segment to be set to the context")
+	}
+
+	checkCtx, check := StartActionCtx(approveCtx, "check", "check limit")
+	_, bureau := StartActionCtx(checkCtx, "bureau", "call bureau")
+	bureau.Succeed().End()
+	check.Succeed().End()
+	_, ledger := StartActionCtx(approveCtx, "ledger", "update ledger")
+	ledger.Succeed().End()
+	approve.Succeed().End()
+
+	expected := map[string]string{
+		"approve": "",
+		"check":   approve.Activity.ID,
+		"bureau":  check.Activity.ID,
+		"ledger":  approve.Activity.ID,
+	}
+	if len(log.Activities) != len(expected) {
+		t.Fatalf("Expected %d activities, but got %d", len(expected), len(log.Activities))
+	}
+	for _, activity := range log.Activities {
+		if activity.ParentID != expected[activity.Action] {
+			t.Errorf("Expected parent of %s to be %s, but got %s", activity.Action, expected[activity.Action], activity.ParentID)
+		}
+	}
+}
+
+func TestSegmentFromContextIgnoresSegmentOfAnotherTransaction(t *testing.T) {
+	ctx, _ := StartActionCtx(NewContext(context.Background(), &Transaction{}), "outer", "outer")
+
+	log := &Transaction{}
+	ctx = NewContext(ctx, log)
+	if SegmentFromContext(ctx) != nil {
+		t.Errorf("Expected no segment for the new event log")
+	}
+
+	_, segment := StartActionCtx(ctx, "inner", "inner")
+	segment.End()
+	if len(log.Activities) != 1 || log.Activities[0].ParentID != "" {
+		t.Errorf("Expected a top level activity, but got %+v", log.Activities)
+	}
+}
+
+func TestStartActionCtxWithoutTransaction(t *testing.T) {
+	ctx, segment := StartActionCtx(context.Background(), "approve", "approve loan")
+	segment.SetTargetUserID("user").SetDataBefore(1).SetDataAfter(2).Succeed().End()
+
+	_, child := StartActionCtx(ctx, "check", "check limit")
+	child.StartChild("bureau", "call bureau").End()
+	child.End()
+}
+
+func TestRoundTripperNestsInSegment(t *testing.T) {
+	server := newTestVendorServer(t)
+	client := &http.Client{Transport: NewRoundTripper(nil, nil, RoundTripperConfig{Vendor: "pefindo"})}
+
+	log := &Transaction{}
+	ctx, segment := StartActionCtx(NewContext(context.Background(), log), "approve", "approve loan")
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/v1/reports", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error calling vendor: %v", err)
+	}
+	resp.Body.Close()
+	segment.End()
+
+	if len(log.Activities) != 2 {
+		t.Fatalf("Expected 2 activities, but got %d", len(log.Activities))
+	}
+	if call := log.Activities[1]; call.Action != "pefindo" || call.ParentID != segment.Activity.ID {
+		t.Errorf("Expected the call to be nested in %s, but got %s with parent %s", segment.Activity.ID, call.Action, call.ParentID)
+	}
+}
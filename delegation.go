@@ -0,0 +1,171 @@
+package audittrail
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DelegationMode is used to determine how the primary actor acts for the subject.
+type DelegationMode string
+
+const (
+	// DelegationModeImpersonation is used when the actor acts as the subject,
+	// e.g. a CRM agent operating the customer account.
+	DelegationModeImpersonation DelegationMode = "impersonation"
+
+	// DelegationModeDelegation is used when the actor acts on behalf of the subject
+	// with its own identity, e.g. the "act" claim of RFC 8693.
+	DelegationModeDelegation DelegationMode = "delegation"
+)
+
+// Principal is used to store the identity of an actor or a subject.
+type Principal struct {
+
+	// ID is used to store the keycloak id of the principal.
+	ID string `json:"id"`
+
+	// Email is used to store the email of the principal.
+	Email string `json:"email,omitempty"`
+
+	// Type is used to store the type of the principal.
+	Type string `json:"type,omitempty"`
+
+	// ClientID is used to store the client the principal authenticated with.
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// Delegation is used to store on whose behalf the primary actor of the event log acted.
+// The primary actor is stored in Actor, ActorEmail and ActorType of the Transaction.
+type Delegation struct {
+
+	// Mode is used to store how the primary actor acts for the subject.
+	Mode DelegationMode `json:"mode"`
+
+	// Subject is used to store the impersonated or delegated subject.
+	Subject Principal `json:"subject"`
+
+	// Chain is used to store the actors that delegated to the primary actor, the most recent first.
+	// It follows the nested "act" claims of RFC 8693.
+	Chain []Principal `json:"chain,omitempty"`
+
+	// Reason is used to store why the primary actor was authorized to act for the subject,
+	// e.g. a ticket number.
+	Reason string `json:"reason,omitempty"`
+}
+
+// DelegationHeaders is used to configure the headers the impersonated subject is read from.
+// The headers are only used when the ActorResolver did not resolve a delegation from the token.
+//
+// Warning: the headers are not authenticated, any client can send them and claim to act for
+// another customer or employee. They are only accepted when Authorize allows them, or, without
+// Authorize, when the request comes directly from one of the TrustedProxies of the config,
+// e.g. the gateway that verified the impersonation. They are ignored otherwise.
+type DelegationHeaders struct {
+
+	// Subject is used to store the header of the subject ID. Defaults to "X-On-Behalf-Of".
+	Subject string
+
+	// SubjectEmail is used to store the header of the subject email. Defaults to "X-On-Behalf-Of-Email".
+	SubjectEmail string
+
+	// SubjectType is used to store the header of the subject type. Defaults to "X-On-Behalf-Of-Type".
+	SubjectType string
+
+	// Reason is used to store the header of the authorization reason. Defaults to "X-Authorization-Reason".
+	Reason string
+
+	// Mode is used to store the mode of the delegations read from the headers.
+	// Defaults to DelegationModeImpersonation.
+	Mode DelegationMode
+
+	// Authorize is used to decide whether the actor of the event log may act for the subject of the headers,
+	// e.g. by checking the role of the actor. When nil, the headers are only accepted from a trusted proxy.
+	Authorize func(r *http.Request, actor string, delegation Delegation) bool
+}
+
+// To fill the defaults of the delegation headers.
+func (h DelegationHeaders) withDefaults() DelegationHeaders {
+	if h.Subject == "" {
+		h.Subject = "X-On-Behalf-Of"
+	}
+	if h.SubjectEmail == "" {
+		h.SubjectEmail = "X-On-Behalf-Of-Email"
+	}
+	if h.SubjectType == "" {
+		h.SubjectType = "X-On-Behalf-Of-Type"
+	}
+	if h.Reason == "" {
+		h.Reason = "X-Authorization-Reason"
+	}
+	if h.Mode == "" {
+		h.Mode = DelegationModeImpersonation
+	}
+	return h
+}
+
+// To fill the delegation of the event log from the request headers, when they are authorized.
+// The delegation resolved from a verified token wins, only its missing reason is taken from the header.
+func resolveDelegationHeaders(headers *DelegationHeaders, proxies trustedProxies, r *http.Request, log *Transaction) {
+	if headers == nil {
+		return
+	}
+	h := headers.withDefaults()
+
+	reason := strings.TrimSpace(r.Header.Get(h.Reason))
+	if log.Delegation != nil {
+		if log.Delegation.Reason == "" && reason != "" {
+			delegation := *log.Delegation
+			delegation.Reason = reason
+			if h.isAuthorized(proxies, r, log.Actor, delegation) {
+				log.Delegation.Reason = reason
+			}
+		}
+		return
+	}
+
+	subject := strings.TrimSpace(r.Header.Get(h.Subject))
+	if subject == "" {
+		return
+	}
+
+	delegation := Delegation{
+		Mode: h.Mode,
+		Subject: Principal{
+			ID:    subject,
+			Email: strings.TrimSpace(r.Header.Get(h.SubjectEmail)),
+			Type:  strings.TrimSpace(r.Header.Get(h.SubjectType)),
+		},
+		Reason: reason,
+	}
+	if h.isAuthorized(proxies, r, log.Actor, delegation) {
+		log.Delegation = &delegation
+	}
+}
+
+// To determine whether the delegation read from the headers can be trusted.
+func (h DelegationHeaders) isAuthorized(proxies trustedProxies, r *http.Request, actor string, delegation Delegation) bool {
+	if h.Authorize != nil {
+		return h.Authorize(r, actor, delegation)
+	}
+
+	remote, ok := parseHostAddr(r.RemoteAddr)
+	return ok && proxies.contains(remote)
+}
+
+// To read the RFC 8693 "act" claim of a token.
+// The outermost actor is the current actor, the nested actors are the prior actors.
+func actClaimChain(claims map[string]interface{}, name string) []Principal {
+	var chain []Principal
+
+	act, _ := claimValue(claims, name).(map[string]interface{})
+	for act != nil {
+		chain = append(chain, Principal{
+			ID:       claimString(act, "sub"),
+			Email:    claimString(act, "email"),
+			ClientID: claimString(act, "client_id"),
+		})
+		act, _ = act["act"].(map[string]interface{})
+	}
+
+	return chain
+}
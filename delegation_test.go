@@ -0,0 +1,154 @@
+package audittrail
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestResolveDelegationHeaders(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("X-On-Behalf-Of", "customer-id")
+	req.Header.Set("X-On-Behalf-Of-Type", "customer")
+	req.Header.Set("X-Authorization-Reason", "TICKET-123")
+
+	gateway := newTrustedProxies([]string{"192.0.2.1"})
+
+	t.Run("reads the impersonated subject", func(t *testing.T) {
+		log := &Transaction{Actor: "agent-id"}
+		resolveDelegationHeaders(&DelegationHeaders{}, gateway, req, log)
+
+		expected := &Delegation{
+			Mode:    DelegationModeImpersonation,
+			Subject: Principal{ID: "customer-id", Type: "customer"},
+			Reason:  "TICKET-123",
+		}
+		if !reflect.DeepEqual(log.Delegation, expected) {
+			t.Errorf("Expected delegation to be %+v, but got %+v", expected, log.Delegation)
+		}
+	})
+
+	t.Run("keeps the delegation of the token", func(t *testing.T) {
+		log := &Transaction{Delegation: &Delegation{Mode: DelegationModeDelegation, Subject: Principal{ID: "token-subject"}}}
+		resolveDelegationHeaders(&DelegationHeaders{}, gateway, req, log)
+
+		if log.Delegation.Subject.ID != "token-subject" {
+			t.Errorf("Expected subject to be %s, but got %s", "token-subject", log.Delegation.Subject.ID)
+		}
+		if log.Delegation.Reason != "TICKET-123" {
+			t.Errorf("Expected reason to be %s, but got %s", "TICKET-123", log.Delegation.Reason)
+		}
+	})
+
+	t.Run("is disabled without config", func(t *testing.T) {
+		log := &Transaction{}
+		resolveDelegationHeaders(nil, gateway, req, log)
+
+		if log.Delegation != nil {
+			t.Errorf("Expected no delegation, but got %+v", log.Delegation)
+		}
+	})
+
+	t.Run("ignores the headers of an untrusted peer", func(t *testing.T) {
+		log := &Transaction{Actor: "agent-id"}
+		resolveDelegationHeaders(&DelegationHeaders{}, newTrustedProxies([]string{"10.0.0.0/8"}), req, log)
+
+		if log.Delegation != nil {
+			t.Errorf("Expected no delegation, but got %+v", log.Delegation)
+		}
+
+		log = &Transaction{Delegation: &Delegation{Subject: Principal{ID: "token-subject"}}}
+		resolveDelegationHeaders(&DelegationHeaders{}, nil, req, log)
+
+		if log.Delegation.Reason != "" {
+			t.Errorf("Expected the reason to be ignored, but got %s", log.Delegation.Reason)
+		}
+	})
+
+	t.Run("asks the authorize callback", func(t *testing.T) {
+		headers := &DelegationHeaders{Authorize: func(r *http.Request, actor string, delegation Delegation) bool {
+			return actor == "agent-id" && delegation.Subject.Type == "customer"
+		}}
+
+		log := &Transaction{Actor: "agent-id"}
+		resolveDelegationHeaders(headers, nil, req, log)
+		if log.Delegation == nil || log.Delegation.Subject.ID != "customer-id" {
+			t.Errorf("Expected the authorized delegation, but got %+v", log.Delegation)
+		}
+
+		log = &Transaction{Actor: "customer-id"}
+		resolveDelegationHeaders(headers, gateway, req, log)
+		if log.Delegation != nil {
+			t.Errorf("Expected the unauthorized delegation to be ignored, but got %+v", log.Delegation)
+		}
+	})
+}
+
+func TestJWTActorResolverDelegation(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	resolver, err := NewJWTActorResolver(JWTActorResolverConfig{
+		JWKSFile:    newTestJWKS(t, "kid-1", &key.PublicKey),
+		ActorType:   "user",
+		ReasonClaim: "reason",
+	})
+	if err != nil {
+		t.Fatalf("Error creating resolver: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+signTestToken(t, "kid-1", key, jwt.MapClaims{
+		"sub":    "customer-id",
+		"email":  "customer@example.com",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"reason": "TICKET-123",
+		"act": map[string]interface{}{
+			"sub":   "agent-id",
+			"email": "agent@example.com",
+			"act":   map[string]interface{}{"sub": "crm-backoffice", "client_id": "crm-web"},
+		},
+	}))
+
+	actor, err := resolver.ResolveActor(req)
+	if err != nil {
+		t.Fatalf("Error resolving actor: %v", err)
+	}
+
+	if actor.ID != "agent-id" || actor.Email != "agent@example.com" {
+		t.Errorf("Expected the primary actor to be agent-id, but got %+v", actor)
+	}
+
+	expected := &Delegation{
+		Mode:    DelegationModeDelegation,
+		Subject: Principal{ID: "customer-id", Email: "customer@example.com", Type: "user"},
+		Chain:   []Principal{{ID: "crm-backoffice", ClientID: "crm-web"}},
+		Reason:  "TICKET-123",
+	}
+	if !reflect.DeepEqual(actor.Delegation, expected) {
+		t.Errorf("Expected delegation to be %+v, but got %+v", expected, actor.Delegation)
+	}
+}
+
+func TestTransactionSetOnBehalfOf(t *testing.T) {
+	log := &Transaction{Actor: "agent-id"}
+	log.SetOnBehalfOf(Principal{ID: "customer-id"}, DelegationModeImpersonation).
+		SetAuthorizationReason("TICKET-123")
+
+	payload := string(log.GetPayloadTransaction())
+	expected := `"delegation":{"mode":"impersonation","subject":{"id":"customer-id"},"reason":"TICKET-123"}`
+	if !strings.Contains(payload, expected) {
+		t.Errorf("Expected payload to contain %s, but got %s", expected, payload)
+	}
+
+	var decoded Transaction
+	if err := json.Unmarshal([]byte(payload), &decoded); err != nil || decoded.Delegation.Subject.ID != "customer-id" {
+		t.Errorf("Expected the delegation to round trip, but got %+v and %v", decoded.Delegation, err)
+	}
+}
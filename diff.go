@@ -0,0 +1,304 @@
+package audittrail
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// ChangeOp is the kind of a change between DataBefore and DataAfter.
+type ChangeOp string
+
+const (
+	// ChangeOpAdd is used when the value was added.
+	ChangeOpAdd ChangeOp = "add"
+
+	// ChangeOpRemove is used when the value was removed.
+	ChangeOpRemove ChangeOp = "remove"
+
+	// ChangeOpReplace is used when the value was changed.
+	ChangeOpReplace ChangeOp = "replace"
+
+	// ChangeOpTypeChange is used when the value was changed to another JSON type, e.g. a string to an object.
+	ChangeOpTypeChange ChangeOp = "typeChange"
+)
+
+// Change is used to store a field level change between DataBefore and DataAfter.
+type Change struct {
+
+	// Path is used to store the JSON path of the changed value, e.g. "$.address.city".
+	// Elements of arrays matched by key are selected by their key, e.g. "$.items[id=42].qty".
+	Path string `json:"path"`
+
+	// Op is used to store the kind of the change.
+	Op ChangeOp `json:"op"`
+
+	// Old is used to store the value before the change.
+	Old interface{} `json:"old,omitempty"`
+
+	// New is used to store the value after the change.
+	New interface{} `json:"new,omitempty"`
+}
+
+// DiffOptions is used to configure the change set of a Segment.
+type DiffOptions struct {
+
+	// ArrayKeys is used to match the elements of arrays by a key field instead of their index,
+	// by the path of the array, e.g. {"$.items": "id", "$.items[*].children": "id"}.
+	// Arrays with an element without the key are matched by index.
+	ArrayKeys map[string]string
+
+	// IsDiffOnly is used to determine whether DataBefore and DataAfter are dropped
+	// once the change set is computed, e.g. for large entities.
+	IsDiffOnly bool
+
+	// PatchFormat is used to record the change as a JSON Patch, a Merge Patch or both.
+	// Defaults to the PatchFormat of the config, no patch is recorded when both are empty.
+	PatchFormat PatchFormat
+}
+
+// Diff computes the field level changes between two values.
+// The values are compared in their JSON form, with the audit tags applied.
+func Diff(before interface{}, after interface{}, options DiffOptions) []Change {
+	return diffValues(before, after, options, processHashKey)
+}
+
+func diffValues(before interface{}, after interface{}, options DiffOptions, hashKey []byte) []Change {
+	d := differ{options: options}
+	d.diff("$", "$", normalizeJSON(before, hashKey), normalizeJSON(after, hashKey))
+	return d.changes
+}
+
+// To convert the value into its generic JSON form, with the audit tags applied.
+func normalizeJSON(value interface{}, hashKey []byte) interface{} {
+	payload, err := json.Marshal(applyAuditTags(value, hashKey))
+	if err != nil {
+		return nil
+	}
+
+	var result interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil
+	}
+	return result
+}
+
+type differ struct {
+	options DiffOptions
+	changes []Change
+}
+
+// To compare two JSON values.
+// The pattern is the path with the array elements written as [*], to look up the array keys.
+func (d *differ) diff(path string, pattern string, old interface{}, new interface{}) {
+	switch {
+	case old == nil && new == nil:
+		return
+	case old == nil || new == nil:
+		d.changes = append(d.changes, Change{Path: path, Op: ChangeOpReplace, Old: old, New: new})
+		return
+	}
+
+	oldType, newType := jsonType(old), jsonType(new)
+	if oldType != newType {
+		d.changes = append(d.changes, Change{Path: path, Op: ChangeOpTypeChange, Old: old, New: new})
+		return
+	}
+
+	switch oldType {
+	case "object":
+		d.diffObjects(path, pattern, old.(map[string]interface{}), new.(map[string]interface{}))
+	case "array":
+		d.diffArrays(path, pattern, old.([]interface{}), new.([]interface{}))
+	default:
+		if stringifyJSON(old) != stringifyJSON(new) {
+			d.changes = append(d.changes, Change{Path: path, Op: ChangeOpReplace, Old: old, New: new})
+		}
+	}
+}
+
+func (d *differ) diffObjects(path string, pattern string, old map[string]interface{}, new map[string]interface{}) {
+	keys := make([]string, 0, len(old)+len(new))
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range new {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		oldValue, inOld := old[key]
+		newValue, inNew := new[key]
+		child := changePathKey(key)
+
+		switch {
+		case !inNew:
+			d.changes = append(d.changes, Change{Path: path + child, Op: ChangeOpRemove, Old: oldValue})
+		case !inOld:
+			d.changes = append(d.changes, Change{Path: path + child, Op: ChangeOpAdd, New: newValue})
+		default:
+			d.diff(path+child, pattern+child, oldValue, newValue)
+		}
+	}
+}
+
+func (d *differ) diffArrays(path string, pattern string, old []interface{}, new []interface{}) {
+	if key, ok := d.options.ArrayKeys[pattern]; ok {
+		oldKeys, oldOk := arrayElementKeys(old, key)
+		newKeys, newOk := arrayElementKeys(new, key)
+		if oldOk && newOk {
+			d.diffKeyedArrays(path, pattern, key, old, new, oldKeys, newKeys)
+			return
+		}
+	}
+
+	for i := 0; i < len(old) || i < len(new); i++ {
+		child := "[" + strconv.Itoa(i) + "]"
+		switch {
+		case i >= len(new):
+			d.changes = append(d.changes, Change{Path: path + child, Op: ChangeOpRemove, Old: old[i]})
+		case i >= len(old):
+			d.changes = append(d.changes, Change{Path: path + child, Op: ChangeOpAdd, New: new[i]})
+		default:
+			d.diff(path+child, pattern+"[*]", old[i], new[i])
+		}
+	}
+}
+
+func (d *differ) diffKeyedArrays(path string, pattern string, key string, old []interface{}, new []interface{}, oldKeys []string, newKeys []string) {
+	newIndex := make(map[string]int, len(newKeys))
+	for i, k := range newKeys {
+		newIndex[k] = i
+	}
+	oldIndex := make(map[string]int, len(oldKeys))
+	for i, k := range oldKeys {
+		oldIndex[k] = i
+	}
+
+	for i, k := range oldKeys {
+		child := "[" + key + "=" + k + "]"
+		if j, ok := newIndex[k]; ok {
+			d.diff(path+child, pattern+"[*]", old[i], new[j])
+			continue
+		}
+		d.changes = append(d.changes, Change{Path: path + child, Op: ChangeOpRemove, Old: old[i]})
+	}
+
+	for j, k := range newKeys {
+		if _, ok := oldIndex[k]; !ok {
+			d.changes = append(d.changes, Change{Path: path + "[" + key + "=" + k + "]", Op: ChangeOpAdd, New: new[j]})
+		}
+	}
+}
+
+// To get the keys of the array elements, in their JSON form.
+// It fails when an element is not an object with a unique key.
+func arrayElementKeys(array []interface{}, key string) ([]string, bool) {
+	keys := make([]string, len(array))
+	seen := make(map[string]bool, len(array))
+	for i, element := range array {
+		object, ok := element.(map[string]interface{})
+		if !ok || object[key] == nil {
+			return nil, false
+		}
+
+		encoded, _ := json.Marshal(object[key])
+		keys[i] = string(encoded)
+		if seen[keys[i]] {
+			return nil, false
+		}
+		seen[keys[i]] = true
+	}
+	return keys, true
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "bool"
+	default:
+		return "unknown"
+	}
+}
+
+var changePathName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// To write the path step of an object key.
+func changePathKey(key string) string {
+	if changePathName.MatchString(key) {
+		return "." + key
+	}
+	return "['" + key + "']"
+}
+
+// changePathKeySelector matches the key selectors of a change path, e.g. [id=42] or [code="A-1"].
+var changePathKeySelector = regexp.MustCompile(`\[[A-Za-z0-9_]+=(?:"(?:[^"\\]|\\.)*"|[^\]]*)\]`)
+
+// To parse the path of a change into a JSON path, the key selectors select a single element.
+func parseChangePath(path string) (jsonPath, bool) {
+	parsed, err := parseJSONPath(changePathKeySelector.ReplaceAllString(path, "[0]"))
+	return parsed, err == nil
+}
+
+// To mask the values of the changes, as if each value was at its path in the data.
+func (s *sanitizer) maskChanges(changes []Change) {
+	for i := range changes {
+		change := &changes[i]
+		path, ok := parseChangePath(change.Path)
+		if !ok {
+			path = nil
+		}
+		change.Old = s.maskAt(path, change.Old)
+		change.New = s.maskAt(path, change.New)
+	}
+}
+
+// To mask a value inside a document that only holds the value at the path.
+func (s *sanitizer) maskAt(path jsonPath, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	var root interface{} = value
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].isIndex {
+			array := make([]interface{}, path[i].index+1)
+			array[path[i].index] = root
+			root = array
+			continue
+		}
+		root = map[string]interface{}{path[i].name: root}
+	}
+
+	root = s.mask(root)
+
+	for _, step := range path {
+		switch node := root.(type) {
+		case map[string]interface{}:
+			root = node[step.name]
+		case []interface{}:
+			if step.index >= len(node) {
+				return nil
+			}
+			root = node[step.index]
+		default:
+			return nil
+		}
+	}
+	return root
+}
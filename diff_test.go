@@ -0,0 +1,94 @@
+package audittrail
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	type address struct {
+		City string `json:"city"`
+		Zip  string `json:"zip,omitempty"`
+	}
+	type customer struct {
+		Name    string   `json:"name"`
+		Address address  `json:"address"`
+		Tags    []string `json:"tags"`
+		Limit   int      `json:"limit"`
+		Note    any      `json:"note"`
+	}
+
+	before := customer{Name: "Budi", Address: address{City: "Jakarta"}, Tags: []string{"vip", "new"}, Limit: 1000, Note: "call back"}
+	after := customer{Name: "Budi", Address: address{City: "Bandung", Zip: "40111"}, Tags: []string{"vip"}, Limit: 2000, Note: map[string]string{"text": "call back"}}
+
+	changes := Diff(before, after, DiffOptions{})
+
+	expected := []Change{
+		{Path: "$.address.city", Op: ChangeOpReplace, Old: "Jakarta", New: "Bandung"},
+		{Path: "$.address.zip", Op: ChangeOpAdd, New: "40111"},
+		{Path: "$.limit", Op: ChangeOpReplace, Old: json.Number("1000"), New: json.Number("2000")},
+		{Path: "$.note", Op: ChangeOpTypeChange, Old: "call back", New: map[string]interface{}{"text": "call back"}},
+		{Path: "$.tags[1]", Op: ChangeOpRemove, Old: "new"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes to be %+v, but got %+v", expected, changes)
+	}
+}
+
+func TestDiffArrayKeys(t *testing.T) {
+	before := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"id": 1, "qty": 1},
+			map[string]interface{}{"id": 2, "qty": 1},
+		},
+	}
+	after := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"id": 3, "qty": 5},
+			map[string]interface{}{"id": 1, "qty": 2},
+		},
+	}
+
+	changes := Diff(before, after, DiffOptions{ArrayKeys: map[string]string{"$.items": "id"}})
+
+	expected := []Change{
+		{Path: "$.items[id=1].qty", Op: ChangeOpReplace, Old: json.Number("1"), New: json.Number("2")},
+		{Path: "$.items[id=2]", Op: ChangeOpRemove, Old: map[string]interface{}{"id": json.Number("2"), "qty": json.Number("1")}},
+		{Path: "$.items[id=3]", Op: ChangeOpAdd, New: map[string]interface{}{"id": json.Number("3"), "qty": json.Number("5")}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes to be %+v, but got %+v", expected, changes)
+	}
+}
+
+func TestSegmentEndComputesChanges(t *testing.T) {
+	log := &Transaction{}
+
+	log.StartAction("update", "update limit").
+		SetDiffOptions(DiffOptions{IsDiffOnly: true}).
+		SetDataBefore(map[string]interface{}{"limit": 1000, "nik": "3171234567890001"}).
+		SetDataAfter(map[string]interface{}{"limit": 2000, "nik": "3171234567890002"}).
+		End()
+
+	activity := log.Activities[0]
+	if activity.DataBefore != nil || activity.DataAfter != nil {
+		t.Errorf("Expected the snapshots to be dropped, but got %v and %v", activity.DataBefore, activity.DataAfter)
+	}
+	if len(activity.Changes) != 2 {
+		t.Fatalf("Expected 2 changes, but got %+v", activity.Changes)
+	}
+
+	log.sanitizer = newSanitizer(ActivityLogConfig{
+		MaskingRules: []MaskingRule{{Path: "$.nik", Strategy: MaskStrategyPartial}},
+	})
+	payload := string(log.GetPayloadTransaction())
+
+	if strings.Contains(payload, "3171234567890001") || strings.Contains(payload, "3171234567890002") {
+		t.Errorf("Expected the changed nik to be masked, but got %s", payload)
+	}
+	if !strings.Contains(payload, `"path":"$.limit"`) {
+		t.Errorf("Expected payload to contain the limit change, but got %s", payload)
+	}
+}
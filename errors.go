@@ -0,0 +1,144 @@
+package audittrail
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// maxStackFrames is the number of frames kept in a recorded stack trace.
+const maxStackFrames = 32
+
+// ErrorDetail is used to store why the event log failed.
+type ErrorDetail struct {
+
+	// Message is used to store the error message or the panic value.
+	Message string `json:"message"`
+
+	// Type is used to store the Go type of the error or the panic value.
+	Type string `json:"type"`
+
+	// Code is used to store the code of the error, see ErrorCoder.
+	Code string `json:"code,omitempty"`
+
+	// Stack is used to store the trimmed stack trace.
+	Stack string `json:"stack,omitempty"`
+
+	// IsPanic is used to determine whether the failure was a panic.
+	IsPanic bool `json:"isPanic"`
+}
+
+// ErrorCoder is implemented by errors that carry a code, e.g. "LOAN_LIMIT_EXCEEDED".
+// The code of a failed activity is read from the first error of the chain implementing it,
+// else from the first error carrying a gRPC status, else from the first with a StatusCode() int.
+type ErrorCoder interface {
+	ErrorCode() string
+}
+
+// To create the error detail of an error.
+// The stack of the caller is recorded when isRecordStack is set.
+func newErrorDetail(err error, isRecordStack bool, skip int) *ErrorDetail {
+	detail := &ErrorDetail{
+		Message: err.Error(),
+		Type:    errorType(err),
+		Code:    errorCode(err),
+	}
+	if isRecordStack {
+		detail.Stack = trimmedStack(skip + 1)
+	}
+	return detail
+}
+
+// To get the type of the error, without the wrappers of fmt.Errorf.
+func errorType(err error) string {
+	for {
+		typ := fmt.Sprintf("%T", err)
+		if typ != "*fmt.wrapError" {
+			return typ
+		}
+		err = errors.Unwrap(err)
+	}
+}
+
+// To get the code of the error, empty when no error of the chain carries one.
+func errorCode(err error) string {
+	var coder ErrorCoder
+	if errors.As(err, &coder) {
+		return coder.ErrorCode()
+	}
+
+	if code, ok := grpcStatusCode(err); ok {
+		return code
+	}
+
+	var statusCoder interface{ StatusCode() int }
+	if errors.As(err, &statusCoder) {
+		return strconv.Itoa(statusCoder.StatusCode())
+	}
+	return ""
+}
+
+// To get the code of the first error of the chain carrying a gRPC status.
+// The status is read by its GRPCStatus method, so the package does not depend on grpc.
+func grpcStatusCode(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+
+	if method := reflect.ValueOf(err).MethodByName("GRPCStatus"); method.IsValid() && method.Type().NumIn() == 0 && method.Type().NumOut() == 1 {
+		code := method.Call(nil)[0].MethodByName("Code")
+		if code.IsValid() && code.Type().NumIn() == 0 && code.Type().NumOut() == 1 {
+			if stringer, ok := code.Call(nil)[0].Interface().(fmt.Stringer); ok {
+				return stringer.String(), true
+			}
+		}
+	}
+
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() error }:
+		return grpcStatusCode(wrapped.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, err := range wrapped.Unwrap() {
+			if code, ok := grpcStatusCode(err); ok {
+				return code, true
+			}
+		}
+	}
+	return "", false
+}
+
+// To create the error detail of a recovered panic.
+// skip is the number of frames between the deferred function that recovered and the caller.
+func newPanicError(value interface{}, skip int) *ErrorDetail {
+	return &ErrorDetail{
+		Message: fmt.Sprint(value),
+		Type:    fmt.Sprintf("%T", value),
+		Stack:   trimmedStack(skip + 3),
+		IsPanic: true,
+	}
+}
+
+// To format the stack of the caller, without the runtime frames
+// and cut to maxStackFrames frames.
+func trimmedStack(skip int) string {
+	pcs := make([]uintptr, maxStackFrames+16)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var b strings.Builder
+	count := 0
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+			count++
+		}
+		if !more || count == maxStackFrames {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,118 @@
+package audittrail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type limitError struct {
+	limit int
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("limit %d exceeded", e.limit)
+}
+
+func (e *limitError) ErrorCode() string {
+	return "LOAN_LIMIT_EXCEEDED"
+}
+
+func TestSegmentFail(t *testing.T) {
+	log := &Transaction{isRecordErrorStack: true}
+
+	segment := log.StartAction("approve", "approve loan")
+	segment.Succeed()
+	segment.Fail(fmt.Errorf("approve loan: %w", &limitError{limit: 1000}))
+	segment.End()
+
+	activity := log.Activities[0]
+	if activity.Status != "failed" {
+		t.Errorf("Expected Status to be %s, but got %s", "failed", activity.Status)
+	}
+
+	detail := activity.Error
+	if detail == nil {
+		t.Fatalf("Expected the error to be recorded")
+	}
+	if detail.Message != "approve loan: limit 1000 exceeded" {
+		t.Errorf("Expected Message to be %s, but got %s", "approve loan: limit 1000 exceeded", detail.Message)
+	}
+	if detail.Type != "*audittrail.limitError" {
+		t.Errorf("Expected Type to be %s, but got %s", "*audittrail.limitError", detail.Type)
+	}
+	if detail.Code != "LOAN_LIMIT_EXCEEDED" {
+		t.Errorf("Expected Code to be %s, but got %s", "LOAN_LIMIT_EXCEEDED", detail.Code)
+	}
+	if !strings.HasPrefix(detail.Stack, "github.com/raihansuwanto/audit-trail.TestSegmentFail") {
+		t.Errorf("Expected the stack to start at the caller, but got %s", detail.Stack)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{status.Error(codes.NotFound, "loan not found"), "NotFound"},
+		{fmt.Errorf("call bureau: %w", status.Error(codes.Unavailable, "down")), "Unavailable"},
+		{errors.Join(errors.New("rollback"), status.Error(codes.Aborted, "conflict")), "Aborted"},
+		{errors.New("plain"), ""},
+	}
+
+	for _, test := range tests {
+		if code := errorCode(test.err); code != test.code {
+			t.Errorf("Expected Code to be %s, but got %s", test.code, code)
+		}
+	}
+}
+
+func TestSegmentFinish(t *testing.T) {
+	log := &Transaction{}
+
+	approve := func(fail bool) (err error) {
+		segment := log.StartAction("approve", "approve loan")
+		defer segment.Finish(&err)
+
+		if fail {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+
+	approve(false)
+	approve(true)
+
+	if len(log.Activities) != 2 {
+		t.Fatalf("Expected 2 activities, but got %d", len(log.Activities))
+	}
+	if log.Activities[0].Status != "success" || log.Activities[0].Error != nil {
+		t.Errorf("Expected the first activity to succeed, but got %+v", log.Activities[0])
+	}
+	if log.Activities[1].Status != "failed" || log.Activities[1].Error.Message != "rejected" || log.Activities[1].Error.Stack != "" {
+		t.Errorf("Expected the second activity to fail without a stack, but got %+v", log.Activities[1].Error)
+	}
+}
+
+func TestSegmentFinishRecordsPanic(t *testing.T) {
+	log := &Transaction{}
+
+	defer func() {
+		if recovered := recover(); recovered != "boom" {
+			t.Errorf("Expected the panic to be propagated, but got %v", recovered)
+		}
+
+		if len(log.Activities) != 1 || log.Activities[0].Error == nil || !log.Activities[0].Error.IsPanic {
+			t.Errorf("Expected the panic to be recorded, but got %+v", log.Activities)
+		}
+	}()
+
+	func() (err error) {
+		defer log.StartAction("approve", "approve loan").Finish(&err)
+		panic("boom")
+	}()
+}
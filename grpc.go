@@ -0,0 +1,11 @@
+package audittrail
+
+// GRPCStatus is used to store the status of a gRPC call.
+type GRPCStatus struct {
+
+	// Code is used to store the name of the status code, e.g. "NotFound".
+	Code string `json:"code"`
+
+	// Message is used to store the status message.
+	Message string `json:"message,omitempty"`
+}
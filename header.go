@@ -0,0 +1,149 @@
+package audittrail
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// HeaderMaskMode is enum string.
+// It is used to determine how a sensitive header value is recorded.
+type HeaderMaskMode string
+
+const (
+	// HeaderMaskModeReplace replaces every value of the header with "***".
+	// It is the default mode when nothing is configured.
+	HeaderMaskModeReplace HeaderMaskMode = "replace"
+
+	// HeaderMaskModeDrop removes the header from the event log.
+	HeaderMaskModeDrop HeaderMaskMode = "drop"
+
+	// HeaderMaskModeHash replaces every value of the header with a short keyed-hash fingerprint.
+	HeaderMaskModeHash HeaderMaskMode = "hash"
+)
+
+// MaskedValue is the value recorded in place of a masked value.
+const MaskedValue = "***"
+
+// DefaultSensitiveHeaders is the list of headers that are always masked,
+// even when ActivityLogConfig does not configure any header denylist.
+var DefaultSensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Access-Token",
+	"X-Refresh-Token",
+	"X-Session-Id",
+	"X-Session-Token",
+	"X-Csrf-Token",
+	"X-Xsrf-Token",
+	"X-Amz-Security-Token",
+}
+
+// processHashKey is used to fingerprint header values when no HeaderHashKey is configured.
+// Fingerprints made with it can only be correlated within the same process.
+var processHashKey = func() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}()
+
+type headerRedactor struct {
+	allow   map[string]struct{}
+	deny    map[string]struct{}
+	mode    HeaderMaskMode
+	hashKey []byte
+}
+
+func newHeaderRedactor(cfg ActivityLogConfig) *headerRedactor {
+	h := &headerRedactor{
+		deny:    make(map[string]struct{}),
+		mode:    cfg.HeaderMaskMode,
+		hashKey: cfg.HeaderHashKey,
+	}
+
+	if h.mode == "" {
+		h.mode = HeaderMaskModeReplace
+	}
+
+	if len(h.hashKey) == 0 {
+		h.hashKey = processHashKey
+	}
+
+	if len(cfg.HeaderAllowlist) != 0 {
+		h.allow = make(map[string]struct{}, len(cfg.HeaderAllowlist))
+		for _, name := range cfg.HeaderAllowlist {
+			h.allow[strings.ToLower(name)] = struct{}{}
+		}
+	}
+
+	for _, name := range DefaultSensitiveHeaders {
+		h.deny[strings.ToLower(name)] = struct{}{}
+	}
+
+	for _, name := range cfg.HeaderDenylist {
+		h.deny[strings.ToLower(name)] = struct{}{}
+	}
+
+	for _, name := range actorSensitiveHeaders(cfg.ActorResolver) {
+		h.deny[strings.ToLower(name)] = struct{}{}
+	}
+
+	return h
+}
+
+// To build the header of the event log from the request header.
+// Headers outside the allowlist are skipped and denylisted headers are masked.
+func (h *headerRedactor) redact(header http.Header) map[string]interface{} {
+	result := make(map[string]interface{})
+	for k, v := range header {
+		name := strings.ToLower(k)
+
+		if h.allow != nil {
+			if _, ok := h.allow[name]; !ok {
+				continue
+			}
+		}
+
+		if _, ok := h.deny[name]; !ok {
+			result[k] = v
+			continue
+		}
+
+		switch h.mode {
+		case HeaderMaskModeDrop:
+			continue
+		case HeaderMaskModeHash:
+			masked := make([]string, len(v))
+			for i, value := range v {
+				masked[i] = fingerprint(h.hashKey, value)
+			}
+			result[k] = masked
+		default:
+			masked := make([]string, len(v))
+			for i := range v {
+				masked[i] = MaskedValue
+			}
+			result[k] = masked
+		}
+	}
+	return result
+}
+
+// To create a short keyed-hash fingerprint of the value.
+func fingerprint(key []byte, value string) string {
+	return "hmac-sha256:" + keyedHash(key, value)[:16]
+}
+
+// To create the hex encoded HMAC-SHA256 of the value.
+func keyedHash(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,66 @@
+package audittrail
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeaderRedactor(t *testing.T) {
+	header := http.Header{
+		"Authorization": []string{"Bearer secret"},
+		"Cookie":        []string{"session=abc"},
+		"X-Request-Id":  []string{"req-1"},
+		"X-Partner-Key": []string{"partner-secret"},
+	}
+
+	t.Run("masks default sensitive headers when nothing is configured", func(t *testing.T) {
+		result := newHeaderRedactor(ActivityLogConfig{}).redact(header)
+
+		if !reflect.DeepEqual(result["Authorization"], []string{MaskedValue}) {
+			t.Errorf("Expected Authorization to be masked, but got %v", result["Authorization"])
+		}
+
+		if !reflect.DeepEqual(result["Cookie"], []string{MaskedValue}) {
+			t.Errorf("Expected Cookie to be masked, but got %v", result["Cookie"])
+		}
+
+		if !reflect.DeepEqual(result["X-Request-Id"], []string{"req-1"}) {
+			t.Errorf("Expected X-Request-Id to be %v, but got %v", []string{"req-1"}, result["X-Request-Id"])
+		}
+	})
+
+	t.Run("matches allowlist and denylist case-insensitively", func(t *testing.T) {
+		result := newHeaderRedactor(ActivityLogConfig{
+			HeaderAllowlist: []string{"x-request-id", "x-partner-key", "AUTHORIZATION"},
+			HeaderDenylist:  []string{"x-PARTNER-key"},
+			HeaderMaskMode:  HeaderMaskModeDrop,
+		}).redact(header)
+
+		if len(result) != 1 {
+			t.Errorf("Expected 1 header, but got %d: %v", len(result), result)
+		}
+
+		if !reflect.DeepEqual(result["X-Request-Id"], []string{"req-1"}) {
+			t.Errorf("Expected X-Request-Id to be %v, but got %v", []string{"req-1"}, result["X-Request-Id"])
+		}
+	})
+
+	t.Run("fingerprints values with the configured key", func(t *testing.T) {
+		cfg := ActivityLogConfig{
+			HeaderMaskMode: HeaderMaskModeHash,
+			HeaderHashKey:  []byte("key"),
+		}
+		first := newHeaderRedactor(cfg).redact(header)["Authorization"].([]string)[0]
+		second := newHeaderRedactor(cfg).redact(header)["Authorization"].([]string)[0]
+
+		if !strings.HasPrefix(first, "hmac-sha256:") || strings.Contains(first, "secret") {
+			t.Errorf("Expected Authorization to be fingerprinted, but got %s", first)
+		}
+
+		if first != second {
+			t.Errorf("Expected fingerprints to be stable, but got %s and %s", first, second)
+		}
+	})
+}
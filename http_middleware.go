@@ -0,0 +1,60 @@
+package audittrail
+
+import (
+	"net/http"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// NewHTTPMiddleware creates the activity log middleware for any net/http router.
+// The route pattern of the request is resolved with cfg.RoutePatternResolver,
+// e.g. ServeMuxRoutePattern, ChiRoutePattern, or the resolvers of the audittrailchi and audittrailmux packages.
+func NewHTTPMiddleware(publisher message.Publisher, cfg ActivityLogConfig) func(http.Handler) http.Handler {
+	recorder := NewCallRecorder(publisher, cfg)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			ctx := r.Context()
+
+			call := recorder.Start(r, resolveRoutePattern(cfg.RoutePatternResolver, r))
+			if call == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			log := call.Log
+
+			// Capture the body while the handler reads it, the unread rest is read after the handler
+			var capture *bodyCapture
+			if call.Config.IsRecordRequestBody && r.Body != nil {
+				capture = newBodyCapture(r.Body, call.Config.MaxRequestBodyBytes)
+				r.Body = capture
+			}
+
+			ctx = NewContext(ctx, log)
+
+			rw, w := wrapResponseWriter(w, call.ResponseBodyLimit())
+
+			defer func() {
+				// Record the panic of the handler, then let the upstream recoverers handle it
+				recovered := recover()
+
+				if capture != nil {
+					capture.fill()
+					updateLogWithRequestBody(capture, r, log)
+				}
+
+				updateLogWithResponse(call.Config, rw, log)
+				call.Finish(ctx, rw.effectiveStatusCode(), recovered)
+
+				if recovered != nil {
+					panic(recovered)
+				}
+			}()
+
+			call.RunHandler(func() {
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package audittrail
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTActorResolverConfig is used to configure the bearer token ActorResolver.
+// The defaults follow the Keycloak access token claims.
+type JWTActorResolverConfig struct {
+
+	// JWKSFile is used to store the path of a JWKS file with the verification keys.
+	JWKSFile string
+
+	// JWKS is used to store the content of a JWKS document, as an alternative to JWKSFile.
+	JWKS []byte
+
+	// Keys is used to store static verification keys by key ID.
+	// The key of tokens without a key ID is stored under "".
+	// Supported keys are *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey and []byte for HMAC.
+	Keys map[string]interface{}
+
+	// Algorithms is used to list the accepted signing algorithms.
+	// Defaults to the RSA, RSA-PSS, ECDSA and EdDSA algorithms. HMAC must be listed explicitly.
+	Algorithms []string
+
+	// Issuer is used to store the expected issuer, it is not checked when empty.
+	Issuer string
+
+	// Audience is used to store the expected audience, it is not checked when empty.
+	Audience string
+
+	// Leeway is used to store the accepted clock skew when checking the token times.
+	Leeway time.Duration
+
+	// ActorClaim is used to store the claim of the actor ID. Defaults to "sub".
+	ActorClaim string
+
+	// EmailClaim is used to store the claim of the actor email. Defaults to "email".
+	EmailClaim string
+
+	// ActorTypeClaim is used to store the claim of the actor type. ActorType is used when empty.
+	ActorTypeClaim string
+
+	// ActorType is used to store the actor type of every token, when ActorTypeClaim is not set.
+	ActorType string
+
+	// RolesClaim is used to store the claim of the realm roles. Defaults to "realm_access.roles".
+	// Nested claims are separated by dots.
+	RolesClaim string
+
+	// ClientIDClaim is used to store the claim of the client ID. Defaults to "azp".
+	ClientIDClaim string
+
+	// DelegationClaim is used to store the RFC 8693 actor claim. Defaults to "act".
+	// When the token carries it, the actor of the claim is the primary actor
+	// and the token subject is recorded as the delegated subject.
+	DelegationClaim string
+
+	// ReasonClaim is used to store the claim of the authorization reason of a delegation.
+	ReasonClaim string
+}
+
+// JWTActorResolver resolves the actor from a verified bearer JWT.
+type JWTActorResolver struct {
+	cfg    JWTActorResolverConfig
+	keys   map[string]interface{}
+	parser *jwt.Parser
+}
+
+// NewJWTActorResolver creates an ActorResolver that verifies the bearer JWT of the request
+// against locally supplied keys, then reads the actor from its claims.
+func NewJWTActorResolver(cfg JWTActorResolverConfig) (*JWTActorResolver, error) {
+	if cfg.ActorClaim == "" {
+		cfg.ActorClaim = "sub"
+	}
+	if cfg.EmailClaim == "" {
+		cfg.EmailClaim = "email"
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "realm_access.roles"
+	}
+	if cfg.ClientIDClaim == "" {
+		cfg.ClientIDClaim = "azp"
+	}
+	if cfg.DelegationClaim == "" {
+		cfg.DelegationClaim = "act"
+	}
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+	}
+
+	keys := make(map[string]interface{}, len(cfg.Keys))
+	for kid, key := range cfg.Keys {
+		keys[kid] = key
+	}
+
+	jwks := cfg.JWKS
+	if cfg.JWKSFile != "" {
+		content, err := os.ReadFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read jwks file: %w", err)
+		}
+		jwks = content
+	}
+
+	if len(jwks) != 0 {
+		parsed, err := ParseJWKS(jwks)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range parsed {
+			keys[kid] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no jwt verification key configured")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(cfg.Algorithms),
+		jwt.WithLeeway(cfg.Leeway),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+
+	return &JWTActorResolver{
+		cfg:    cfg,
+		keys:   keys,
+		parser: jwt.NewParser(options...),
+	}, nil
+}
+
+// ResolveActor reads the actor from the bearer token of the Authorization header.
+func (j *JWTActorResolver) ResolveActor(r *http.Request) (*ResolvedActor, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+
+	claims, err := j.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	actor := &ResolvedActor{
+		ID:       claimString(claims, j.cfg.ActorClaim),
+		Email:    claimString(claims, j.cfg.EmailClaim),
+		Type:     j.cfg.ActorType,
+		Roles:    claimStrings(claims, j.cfg.RolesClaim),
+		ClientID: claimString(claims, j.cfg.ClientIDClaim),
+		Method:   ActorAuthMethodJWT,
+	}
+	if j.cfg.ActorTypeClaim != "" {
+		actor.Type = claimString(claims, j.cfg.ActorTypeClaim)
+	}
+
+	if chain := actClaimChain(claims, j.cfg.DelegationClaim); len(chain) != 0 {
+		actor.Delegation = &Delegation{
+			Mode: DelegationModeDelegation,
+			Subject: Principal{
+				ID:    actor.ID,
+				Email: actor.Email,
+				Type:  actor.Type,
+			},
+			Chain: chain[1:],
+		}
+		if j.cfg.ReasonClaim != "" {
+			actor.Delegation.Reason = claimString(claims, j.cfg.ReasonClaim)
+		}
+
+		actor.ID = chain[0].ID
+		actor.Email = chain[0].Email
+		actor.Roles = nil
+		if chain[0].ClientID != "" {
+			actor.ClientID = chain[0].ClientID
+		}
+	}
+
+	return actor, nil
+}
+
+// To verify the signature and the times of the token, and return its claims.
+func (j *JWTActorResolver) verify(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := j.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if key, ok := j.keys[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(j.keys) == 1 {
+			for _, key := range j.keys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown jwt key id %q", kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid bearer token: %w", err)
+	}
+	return claims, nil
+}
+
+// To get the bearer token of the Authorization header.
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// To get a claim, nested claims are separated by dots.
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	var value interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claimValue(claims, name).(string)
+	return value
+}
+
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claimValue(claims, name).(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses the verification keys of a JWKS document by key ID.
+// Keys used for encryption are skipped.
+func ParseJWKS(document []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(document, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use == "enc" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwks key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
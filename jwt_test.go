@@ -0,0 +1,134 @@
+package audittrail
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	document, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, document, 0o600); err != nil {
+		t.Fatalf("Error writing jwks: %v", err)
+	}
+	return path
+}
+
+func signTestToken(t *testing.T, kid string, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	return signed
+}
+
+func TestJWTActorResolver(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	resolver, err := NewJWTActorResolver(JWTActorResolverConfig{
+		JWKSFile:  newTestJWKS(t, "kid-1", &key.PublicKey),
+		Issuer:    "https://sso.example.com/realms/crm",
+		ActorType: "user",
+	})
+	if err != nil {
+		t.Fatalf("Error creating resolver: %v", err)
+	}
+
+	claims := jwt.MapClaims{
+		"sub":          "keycloak-id",
+		"email":        "agent@example.com",
+		"azp":          "crm-web",
+		"iss":          "https://sso.example.com/realms/crm",
+		"exp":          time.Now().Add(time.Hour).Unix(),
+		"realm_access": map[string]interface{}{"roles": []string{"agent", "supervisor"}},
+	}
+
+	t.Run("resolves the actor from a verified token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestToken(t, "kid-1", key, claims))
+
+		actor, err := resolver.ResolveActor(req)
+		if err != nil {
+			t.Fatalf("Error resolving actor: %v", err)
+		}
+
+		expected := &ResolvedActor{
+			ID:       "keycloak-id",
+			Email:    "agent@example.com",
+			Type:     "user",
+			Roles:    []string{"agent", "supervisor"},
+			ClientID: "crm-web",
+			Method:   ActorAuthMethodJWT,
+		}
+		if !reflect.DeepEqual(actor, expected) {
+			t.Errorf("Expected actor to be %+v, but got %+v", expected, actor)
+		}
+	})
+
+	t.Run("rejects tokens signed with another key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestToken(t, "kid-1", otherKey, claims))
+
+		if _, err := resolver.ResolveActor(req); err == nil {
+			t.Errorf("Expected an error for a forged token")
+		}
+	})
+
+	t.Run("rejects expired tokens", func(t *testing.T) {
+		expired := jwt.MapClaims{"sub": "keycloak-id", "iss": claims["iss"], "exp": time.Now().Add(-time.Hour).Unix()}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestToken(t, "kid-1", key, expired))
+
+		if _, err := resolver.ResolveActor(req); !errors.Is(err, jwt.ErrTokenExpired) {
+			t.Errorf("Expected %v, but got %v", jwt.ErrTokenExpired, err)
+		}
+	})
+
+	t.Run("ignores requests without bearer token", func(t *testing.T) {
+		actor, err := resolver.ResolveActor(httptest.NewRequest("GET", "/", nil))
+		if actor != nil || err != nil {
+			t.Errorf("Expected no actor and no error, but got %+v and %v", actor, err)
+		}
+	})
+}
+
+func TestResolveActor(t *testing.T) {
+	log := &Transaction{ActorType: "user", ActorEmail: "default@example.com"}
+
+	resolveActor(ActorResolverFunc(func(r *http.Request) (*ResolvedActor, error) {
+		return &ResolvedActor{ID: "keycloak-id", Roles: []string{"agent"}}, nil
+	}), httptest.NewRequest("GET", "/", nil), log)
+
+	if log.Actor != "keycloak-id" || log.ActorType != "user" || log.ActorEmail != "default@example.com" {
+		t.Errorf("Expected actor to be resolved on top of the defaults, but got %+v", log)
+	}
+
+	if !reflect.DeepEqual(log.ActorRoles, []string{"agent"}) {
+		t.Errorf("Expected ActorRoles to be %v, but got %v", []string{"agent"}, log.ActorRoles)
+	}
+}
@@ -0,0 +1,463 @@
+package audittrail
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaskStrategy is enum string.
+// It is used to determine how a value matched by a MaskingRule is masked.
+type MaskStrategy string
+
+const (
+	// MaskStrategyRemove removes the value from the event log.
+	MaskStrategyRemove MaskStrategy = "remove"
+
+	// MaskStrategyPartial replaces every character but the last 4 with "*".
+	MaskStrategyPartial MaskStrategy = "partial"
+
+	// MaskStrategyHash replaces the value with a keyed HMAC-SHA256 hash.
+	MaskStrategyHash MaskStrategy = "hash"
+
+	// MaskStrategyTokenize replaces the value with a token issued by the configured Tokenizer.
+	MaskStrategyTokenize MaskStrategy = "tokenize"
+)
+
+// MaskingRule is used to mask the values matched by a JSON path.
+//
+// The path supports the root ($), child (.name or ['name']), index ([0]),
+// wildcard (.* or [*]) and recursive descent (..name) selectors.
+//
+// Example:
+//
+//	[]activitylog.MaskingRule{
+//		{Path: "$.password", Strategy: activitylog.MaskStrategyRemove},
+//		{Path: "$.customer.nik", Strategy: activitylog.MaskStrategyHash},
+//		{Path: "$..cardNumber", Strategy: activitylog.MaskStrategyPartial},
+//	}
+type MaskingRule struct {
+
+	// Path is used to store the JSON path of the masked values.
+	Path string
+
+	// Strategy is used to store the strategy used to mask the values.
+	Strategy MaskStrategy
+}
+
+// Tokenizer is used to replace a sensitive value with a token, e.g. by storing it in a vault.
+type Tokenizer interface {
+
+	// Tokenize returns the token of the value.
+	Tokenize(value string) (string, error)
+}
+
+// hmacTokenizer is the default Tokenizer.
+// It issues deterministic tokens that can not be reversed.
+type hmacTokenizer struct {
+	key []byte
+}
+
+func (t hmacTokenizer) Tokenize(value string) (string, error) {
+	return "tok_" + keyedHash(t.key, value)[:32], nil
+}
+
+type pathStep struct {
+	name      string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+}
+
+type jsonPath []pathStep
+
+type compiledRule struct {
+	path     jsonPath
+	strategy MaskStrategy
+}
+
+// To parse the JSON path of a masking rule.
+func parseJSONPath(path string) (jsonPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path %q must start with $", path)
+	}
+
+	var steps jsonPath
+	rest := path[1:]
+	for rest != "" {
+		var step pathStep
+
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] == '[':
+		default:
+			return nil, fmt.Errorf("json path %q: unexpected %q", path, rest)
+		}
+
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("json path %q: missing ]", path)
+			}
+			selector := rest[1:end]
+			rest = rest[end+1:]
+
+			switch {
+			case selector == "*":
+				step.wildcard = true
+			case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+				step.name = selector[1 : len(selector)-1]
+			default:
+				index, err := strconv.Atoi(selector)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("json path %q: invalid index %q", path, selector)
+				}
+				step.index = index
+				step.isIndex = true
+			}
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			step.name = rest[:end]
+			rest = rest[end:]
+
+			if step.name == "" {
+				return nil, fmt.Errorf("json path %q: empty name", path)
+			}
+			if step.name == "*" {
+				step.name = ""
+				step.wildcard = true
+			}
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// pathRef is a reference to a value inside a decoded JSON document.
+type pathRef struct {
+	object map[string]interface{}
+	array  []interface{}
+	key    string
+	index  int
+}
+
+func (r pathRef) get() interface{} {
+	if r.object != nil {
+		return r.object[r.key]
+	}
+	return r.array[r.index]
+}
+
+func (r pathRef) set(value interface{}) {
+	if r.object != nil {
+		r.object[r.key] = value
+		return
+	}
+	r.array[r.index] = value
+}
+
+func (r pathRef) remove() {
+	if r.object != nil {
+		delete(r.object, r.key)
+		return
+	}
+	r.array[r.index] = nil
+}
+
+// To find the references matched by the step on the node.
+func (s pathStep) match(node interface{}) []pathRef {
+	if s.recursive {
+		var refs []pathRef
+		child := s
+		child.recursive = false
+		walkJSON(node, func(n interface{}) {
+			refs = append(refs, child.match(n)...)
+		})
+		return refs
+	}
+
+	var refs []pathRef
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			for k := range n {
+				refs = append(refs, pathRef{object: n, key: k})
+			}
+		} else if _, ok := n[s.name]; ok && !s.isIndex {
+			refs = append(refs, pathRef{object: n, key: s.name})
+		}
+	case []interface{}:
+		if s.wildcard {
+			for i := range n {
+				refs = append(refs, pathRef{array: n, index: i})
+			}
+		} else if s.isIndex && s.index < len(n) {
+			refs = append(refs, pathRef{array: n, index: s.index})
+		}
+	}
+	return refs
+}
+
+// To call fn on the node and every nested value of the node.
+func walkJSON(node interface{}, fn func(interface{})) {
+	fn(node)
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for _, v := range n {
+			walkJSON(v, fn)
+		}
+	case []interface{}:
+		for _, v := range n {
+			walkJSON(v, fn)
+		}
+	}
+}
+
+// To find the references matched by the path on the document.
+func (p jsonPath) refs(root interface{}) []pathRef {
+	nodes := []interface{}{root}
+	var refs []pathRef
+	for i, step := range p {
+		refs = refs[:0:0]
+		for _, node := range nodes {
+			refs = append(refs, step.match(node)...)
+		}
+		if i == len(p)-1 {
+			break
+		}
+		nodes = nodes[:0:0]
+		for _, ref := range refs {
+			nodes = append(nodes, ref.get())
+		}
+	}
+	return refs
+}
+
+type sanitizer struct {
+	rules     []compiledRule
+	hashKey   []byte
+	tokenizer Tokenizer
+	detectors []PIIDetector
+}
+
+// To build the sanitizer applied to the event log before it is published.
+// It panics when a masking rule is invalid, so misconfiguration is caught on startup.
+func newSanitizer(cfg ActivityLogConfig) *sanitizer {
+	if len(cfg.MaskingRules) == 0 && len(cfg.MaskingHashKey) == 0 && !cfg.IsDetectPII {
+		return nil
+	}
+
+	s := &sanitizer{
+		hashKey:   cfg.MaskingHashKey,
+		tokenizer: cfg.Tokenizer,
+	}
+
+	if len(s.hashKey) == 0 {
+		s.hashKey = processHashKey
+	}
+
+	if s.tokenizer == nil {
+		s.tokenizer = hmacTokenizer{key: s.hashKey}
+	}
+
+	if cfg.IsDetectPII {
+		s.detectors = cfg.PIIDetectors
+		if len(s.detectors) == 0 {
+			s.detectors = DefaultPIIDetectors()
+		}
+	}
+
+	for _, rule := range cfg.MaskingRules {
+		path, err := parseJSONPath(rule.Path)
+		if err != nil {
+			panic(fmt.Sprintf("audittrail: invalid masking rule: %v", err))
+		}
+		s.rules = append(s.rules, compiledRule{path: path, strategy: rule.Strategy})
+	}
+
+	return s
+}
+
+// To mask the captured data of the event log.
+// It must only be called on a copy of the event log, since the data is masked in place.
+func (s *sanitizer) sanitize(tx *Transaction) {
+	tx.RequestBody = s.maskObject(tx.RequestBody)
+	tx.ResponseBody = s.maskObject(tx.ResponseBody)
+	tx.RequestBodyValue = s.mask(tx.RequestBodyValue)
+	tx.ResponseBodyValue = s.mask(tx.ResponseBodyValue)
+	tx.Header = s.maskObject(tx.Header)
+	tx.RequestBodyRaw = s.redactRawBody(tx.RequestBodyRaw)
+	tx.ResponseBodyRaw = s.redactRawBody(tx.ResponseBodyRaw)
+
+	for i := range tx.Activities {
+		activity := &tx.Activities[i]
+		activity.RequestData = s.mask(activity.RequestData)
+		activity.ResponseData = s.mask(activity.ResponseData)
+		activity.DataBefore = s.mask(activity.DataBefore)
+		activity.DataAfter = s.mask(activity.DataAfter)
+		s.maskChanges(activity.Changes)
+		s.maskPatches(activity)
+	}
+
+	if len(s.detectors) != 0 {
+		s.detectPII(tx)
+	}
+}
+
+// To mask the personal data found in the captured data of the event log.
+func (s *sanitizer) detectPII(tx *Transaction) {
+	summary := &PIISummary{}
+
+	tx.RequestBody, _ = s.scanPII(tx.RequestBody, "requestBody", summary).(map[string]interface{})
+	tx.ResponseBody, _ = s.scanPII(tx.ResponseBody, "responseBody", summary).(map[string]interface{})
+	tx.RequestBodyValue = s.scanPII(tx.RequestBodyValue, "requestBodyValue", summary)
+	tx.ResponseBodyValue = s.scanPII(tx.ResponseBodyValue, "responseBodyValue", summary)
+	s.scanRawPII(tx.RequestBodyRaw, "requestBodyRaw", summary)
+	s.scanRawPII(tx.ResponseBodyRaw, "responseBodyRaw", summary)
+
+	for i := range tx.Activities {
+		activity := &tx.Activities[i]
+		prefix := "activities[" + strconv.Itoa(i) + "]."
+		activity.RequestData = s.scanPII(activity.RequestData, prefix+"requestData", summary)
+		activity.ResponseData = s.scanPII(activity.ResponseData, prefix+"responseData", summary)
+		activity.DataBefore = s.scanPII(activity.DataBefore, prefix+"dataBefore", summary)
+		activity.DataAfter = s.scanPII(activity.DataAfter, prefix+"dataAfter", summary)
+		for j := range activity.Changes {
+			change := &activity.Changes[j]
+			changePrefix := prefix + "changes[" + strconv.Itoa(j) + "]."
+			change.Old = s.scanPII(change.Old, changePrefix+"old", summary)
+			change.New = s.scanPII(change.New, changePrefix+"new", summary)
+		}
+		for j := range activity.JSONPatch {
+			operation := &activity.JSONPatch[j]
+			operation.Value = s.scanPII(operation.Value, prefix+"jsonPatch["+strconv.Itoa(j)+"].value", summary)
+		}
+		activity.MergePatch = s.scanPII(activity.MergePatch, prefix+"mergePatch", summary)
+	}
+
+	if len(summary.Paths) != 0 {
+		sort.Strings(summary.Paths)
+		tx.PIIDetected = summary
+	}
+}
+
+// To drop the text of a JSON body that could not be decoded, e.g. one cut by the capture limit,
+// since the masking rules can not be applied to it. The size and content type are kept.
+func (s *sanitizer) redactRawBody(raw *RawBody) *RawBody {
+	if s == nil || len(s.rules) == 0 || raw == nil || !isJSONContentType(raw.ContentType) {
+		return raw
+	}
+	redacted := *raw
+	redacted.Encoding = ""
+	redacted.Data = ""
+	redacted.Redacted = true
+	return &redacted
+}
+
+// To redact the activity data when it is a body that could not be decoded, see redactRawBody.
+func (s *sanitizer) redactRawData(data interface{}) interface{} {
+	if raw, ok := data.(*RawBody); ok {
+		return s.redactRawBody(raw)
+	}
+	return data
+}
+
+func (s *sanitizer) maskObject(object map[string]interface{}) map[string]interface{} {
+	if object == nil {
+		return nil
+	}
+	masked, _ := s.mask(object).(map[string]interface{})
+	return masked
+}
+
+// To apply every masking rule to the decoded JSON document.
+func (s *sanitizer) mask(root interface{}) interface{} {
+	if root == nil {
+		return nil
+	}
+
+	for _, rule := range s.rules {
+		if len(rule.path) == 0 {
+			if rule.strategy == MaskStrategyRemove {
+				return nil
+			}
+			root = s.maskValue(root, rule.strategy)
+			continue
+		}
+
+		for _, ref := range rule.path.refs(root) {
+			if rule.strategy == MaskStrategyRemove {
+				ref.remove()
+				continue
+			}
+			ref.set(s.maskValue(ref.get(), rule.strategy))
+		}
+	}
+
+	return root
+}
+
+// To mask a single value with the strategy.
+func (s *sanitizer) maskValue(value interface{}, strategy MaskStrategy) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	str := stringifyJSON(value)
+	switch strategy {
+	case MaskStrategyPartial:
+		return maskPartial(str)
+	case MaskStrategyHash:
+		return "hmac-sha256:" + keyedHash(s.hashKey, str)
+	case MaskStrategyTokenize:
+		token, err := s.tokenizer.Tokenize(str)
+		if err != nil {
+			return MaskedValue
+		}
+		return token
+	default:
+		return MaskedValue
+	}
+}
+
+// To convert a decoded JSON value to its string form.
+func stringifyJSON(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// To replace every character but the last 4 with "*".
+func maskPartial(value string) string {
+	runes := []rune(value)
+	keep := 4
+	if len(runes) <= keep {
+		keep = 0
+	}
+	for i := 0; i < len(runes)-keep; i++ {
+		runes[i] = '*'
+	}
+	return string(runes)
+}
@@ -0,0 +1,128 @@
+package audittrail
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	valid := []string{"$", "$.password", "$.customer.nik", "$..cardNumber", "$.items[*].pan", "$['full name']", "$.items[0]", "$.*"}
+	for _, path := range valid {
+		if _, err := parseJSONPath(path); err != nil {
+			t.Errorf("Expected %s to be valid, but got %v", path, err)
+		}
+	}
+
+	invalid := []string{"password", "$.", "$.items[", "$.items[-1]", "$x"}
+	for _, path := range invalid {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("Expected %s to be invalid, but got no error", path)
+		}
+	}
+}
+
+func TestSanitizer(t *testing.T) {
+	transaction := &Transaction{
+		RequestBody: map[string]interface{}{
+			"password": "secret",
+			"customer": map[string]interface{}{
+				"nik":  "3201234567890001",
+				"name": "Budi",
+			},
+			"cards": []interface{}{
+				map[string]interface{}{"cardNumber": "4111111111111111"},
+			},
+		},
+		Header: map[string]interface{}{
+			"X-Customer-Token": []string{"token"},
+		},
+		sanitizer: newSanitizer(ActivityLogConfig{
+			MaskingRules: []MaskingRule{
+				{Path: "$.password", Strategy: MaskStrategyRemove},
+				{Path: "$.customer.nik", Strategy: MaskStrategyHash},
+				{Path: "$..cardNumber", Strategy: MaskStrategyPartial},
+				{Path: "$['X-Customer-Token'][*]", Strategy: MaskStrategyTokenize},
+				{Path: "$.accountNumber", Strategy: MaskStrategyPartial},
+			},
+		}),
+	}
+
+	segment := transaction.StartAction("update", "update account")
+	segment.SetDataAfter(map[string]interface{}{"accountNumber": 1234567890})
+	segment.End()
+
+	var result Transaction
+	if err := json.Unmarshal(transaction.GetPayloadTransaction(), &result); err != nil {
+		t.Fatalf("Error decoding payload: %v", err)
+	}
+
+	if _, ok := result.RequestBody["password"]; ok {
+		t.Errorf("Expected password to be removed, but got %v", result.RequestBody["password"])
+	}
+
+	customer := result.RequestBody["customer"].(map[string]interface{})
+	if nik := customer["nik"].(string); !strings.HasPrefix(nik, "hmac-sha256:") {
+		t.Errorf("Expected nik to be hashed, but got %s", nik)
+	}
+
+	if customer["name"] != "Budi" {
+		t.Errorf("Expected name to be %s, but got %v", "Budi", customer["name"])
+	}
+
+	card := result.RequestBody["cards"].([]interface{})[0].(map[string]interface{})
+	if card["cardNumber"] != "************1111" {
+		t.Errorf("Expected cardNumber to be %s, but got %v", "************1111", card["cardNumber"])
+	}
+
+	token := result.Header["X-Customer-Token"].([]interface{})[0].(string)
+	if !strings.HasPrefix(token, "tok_") {
+		t.Errorf("Expected header to be tokenized, but got %s", token)
+	}
+
+	after := result.Activities[0].DataAfter.(map[string]interface{})
+	if after["accountNumber"] != "******7890" {
+		t.Errorf("Expected accountNumber to be %s, but got %v", "******7890", after["accountNumber"])
+	}
+
+	if transaction.RequestBody["password"] != "secret" {
+		t.Errorf("Expected the transaction to be left untouched, but got %v", transaction.RequestBody["password"])
+	}
+}
+
+func TestSanitizerRedactsRawJSONBodies(t *testing.T) {
+	transaction := &Transaction{
+		sanitizer: newSanitizer(ActivityLogConfig{
+			MaskingRules: []MaskingRule{{Path: "$.password", Strategy: MaskStrategyRemove}},
+		}),
+	}
+	_, _, transaction.RequestBodyRaw = decodeBody("application/json", []byte(`{"user":"budi","password":"hunter2`), true)
+	_, _, transaction.ResponseBodyRaw = decodeBody("text/plain", []byte("created"), false)
+
+	segment := transaction.StartAction("login", "call identity provider")
+	segment.SetResponseData(bodyData("application/problem+json", []byte(`{"password":"hunter2"`), true))
+	segment.End()
+
+	payload := transaction.GetPayloadTransaction()
+	if strings.Contains(string(payload), "hunter2") {
+		t.Errorf("Expected the raw JSON bodies to be redacted, but got %s", payload)
+	}
+
+	var result Transaction
+	if err := json.Unmarshal(payload, &result); err != nil {
+		t.Fatalf("Error decoding payload: %v", err)
+	}
+
+	raw := result.RequestBodyRaw
+	if raw == nil || !raw.Redacted || raw.Data != "" || raw.ContentType != "application/json" || raw.Size != 34 {
+		t.Errorf("Expected the request body to be redacted with its size and content type, but got %+v", raw)
+	}
+
+	if result.ResponseBodyRaw == nil || result.ResponseBodyRaw.Data != "created" {
+		t.Errorf("Expected the text response body to be kept, but got %+v", result.ResponseBodyRaw)
+	}
+
+	if transaction.RequestBodyRaw.Data == "" {
+		t.Errorf("Expected the transaction to be left untouched, but got %+v", transaction.RequestBodyRaw)
+	}
+}
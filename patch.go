@@ -0,0 +1,485 @@
+package audittrail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PatchFormat is the format of the patch from DataBefore to DataAfter.
+type PatchFormat string
+
+const (
+	// PatchFormatJSONPatch is used to record an RFC 6902 JSON Patch.
+	PatchFormatJSONPatch PatchFormat = "jsonPatch"
+
+	// PatchFormatMergePatch is used to record an RFC 7386 JSON Merge Patch.
+	PatchFormatMergePatch PatchFormat = "mergePatch"
+
+	// PatchFormatBoth is used to record both a JSON Patch and a Merge Patch.
+	PatchFormatBoth PatchFormat = "both"
+)
+
+func (f PatchFormat) isJSONPatch() bool {
+	return f == PatchFormatJSONPatch || f == PatchFormatBoth
+}
+
+func (f PatchFormat) isMergePatch() bool {
+	return f == PatchFormatMergePatch || f == PatchFormatBoth
+}
+
+// PatchOperation is used to store an operation of an RFC 6902 JSON Patch.
+type PatchOperation struct {
+
+	// Op is used to store the operation, e.g. "add", "remove" or "replace".
+	Op string `json:"op"`
+
+	// Path is used to store the JSON pointer of the target, e.g. "/items/0/qty".
+	Path string `json:"path"`
+
+	// From is used to store the JSON pointer of the source of "move" and "copy".
+	From string `json:"from,omitempty"`
+
+	// Value is used to store the value of "add", "replace" and "test".
+	Value interface{} `json:"value,omitempty"`
+}
+
+// To encode the operation, keeping a null value of "add", "replace" and "test".
+func (o PatchOperation) MarshalJSON() ([]byte, error) {
+	type operation PatchOperation
+	if o.Op == "remove" || o.Op == "move" || o.Op == "copy" {
+		return json.Marshal(operation(o))
+	}
+
+	return json.Marshal(&struct {
+		operation
+		Value interface{} `json:"value"`
+	}{
+		operation: operation(o),
+		Value:     o.Value,
+	})
+}
+
+// To compute the patches of the activity in the given format.
+// Nothing is recorded when DataBefore and DataAfter are equal.
+func (a *Activity) setPatches(format PatchFormat, hashKey []byte) {
+	before, after := normalizeJSON(a.DataBefore, hashKey), normalizeJSON(a.DataAfter, hashKey)
+
+	operations := jsonPatch("", before, after, nil)
+	if len(operations) == 0 {
+		return
+	}
+
+	if format.isJSONPatch() {
+		a.JSONPatch = operations
+	}
+	if format.isMergePatch() {
+		a.MergePatch = mergePatch(before, after)
+	}
+}
+
+// To compute the operations that turn old into new.
+// Arrays are patched by index, the trailing elements are removed from the end.
+func jsonPatch(pointer string, old interface{}, new interface{}, operations []PatchOperation) []PatchOperation {
+	oldType, newType := jsonType(old), jsonType(new)
+	if old == nil || new == nil || oldType != newType {
+		if old != nil || new != nil {
+			operations = append(operations, PatchOperation{Op: "replace", Path: pointer, Value: new})
+		}
+		return operations
+	}
+
+	switch oldType {
+	case "object":
+		oldObject, newObject := old.(map[string]interface{}), new.(map[string]interface{})
+		for _, key := range sortedKeys(oldObject, newObject) {
+			oldValue, inOld := oldObject[key]
+			newValue, inNew := newObject[key]
+			child := pointer + "/" + escapePointerToken(key)
+
+			switch {
+			case !inNew:
+				operations = append(operations, PatchOperation{Op: "remove", Path: child})
+			case !inOld:
+				operations = append(operations, PatchOperation{Op: "add", Path: child, Value: newValue})
+			default:
+				operations = jsonPatch(child, oldValue, newValue, operations)
+			}
+		}
+	case "array":
+		oldArray, newArray := old.([]interface{}), new.([]interface{})
+		for i := 0; i < len(oldArray) && i < len(newArray); i++ {
+			operations = jsonPatch(pointer+"/"+strconv.Itoa(i), oldArray[i], newArray[i], operations)
+		}
+		for i := len(oldArray); i < len(newArray); i++ {
+			operations = append(operations, PatchOperation{Op: "add", Path: pointer + "/" + strconv.Itoa(i), Value: newArray[i]})
+		}
+		for i := len(oldArray) - 1; i >= len(newArray); i-- {
+			operations = append(operations, PatchOperation{Op: "remove", Path: pointer + "/" + strconv.Itoa(i)})
+		}
+	default:
+		if stringifyJSON(old) != stringifyJSON(new) {
+			operations = append(operations, PatchOperation{Op: "replace", Path: pointer, Value: new})
+		}
+	}
+	return operations
+}
+
+// To compute the merge patch that turns old into new.
+// A merge patch can not set a value to null, the key is removed instead.
+func mergePatch(old interface{}, new interface{}) interface{} {
+	oldObject, oldOk := old.(map[string]interface{})
+	newObject, newOk := new.(map[string]interface{})
+	if !oldOk || !newOk {
+		return new
+	}
+
+	patch := make(map[string]interface{})
+	for _, key := range sortedKeys(oldObject, newObject) {
+		oldValue, inOld := oldObject[key]
+		newValue, inNew := newObject[key]
+
+		switch {
+		case !inNew:
+			patch[key] = nil
+		case !inOld:
+			patch[key] = newValue
+		default:
+			if jsonPatch("", oldValue, newValue, nil) == nil {
+				continue
+			}
+			patch[key] = mergePatch(oldValue, newValue)
+		}
+	}
+	return patch
+}
+
+func sortedKeys(old map[string]interface{}, new map[string]interface{}) []string {
+	keys := make([]string, 0, len(old)+len(new))
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range new {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+func escapePointerToken(token string) string {
+	return pointerEscaper.Replace(token)
+}
+
+// To split a JSON pointer into its reference tokens, the root pointer has none.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("json pointer %q must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = pointerUnescaper.Replace(token)
+	}
+	return tokens, nil
+}
+
+// To convert a JSON pointer into a JSON path, numeric tokens select array elements.
+func pointerJSONPath(pointer string) (jsonPath, bool) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, false
+	}
+
+	path := make(jsonPath, len(tokens))
+	for i, token := range tokens {
+		if index, err := strconv.Atoi(token); err == nil && index >= 0 {
+			path[i] = pathStep{isIndex: true, index: index}
+			continue
+		}
+		path[i] = pathStep{name: token}
+	}
+	return path, true
+}
+
+// To mask the values of the patches, as if each value was at its path in the data.
+func (s *sanitizer) maskPatches(activity *Activity) {
+	for i := range activity.JSONPatch {
+		operation := &activity.JSONPatch[i]
+		path, ok := pointerJSONPath(operation.Path)
+		if !ok {
+			path = nil
+		}
+		operation.Value = s.maskAt(path, operation.Value)
+	}
+
+	if activity.MergePatch != nil {
+		activity.MergePatch = s.mask(activity.MergePatch)
+	}
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to a copy of the document.
+func ApplyJSONPatch(document interface{}, operations []PatchOperation) (interface{}, error) {
+	result := normalizeJSON(document, processHashKey)
+
+	for _, operation := range operations {
+		var err error
+		result, err = applyPatchOperation(result, operation)
+		if err != nil {
+			return nil, fmt.Errorf("json patch %s %q: %w", operation.Op, operation.Path, err)
+		}
+	}
+	return result, nil
+}
+
+func applyPatchOperation(document interface{}, operation PatchOperation) (interface{}, error) {
+	path, err := parsePointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operation.Op {
+	case "add":
+		return addPointer(document, path, normalizeJSON(operation.Value, processHashKey))
+	case "remove":
+		document, _, err = removePointer(document, path)
+		return document, err
+	case "replace":
+		if _, err := getPointer(document, path); err != nil {
+			return nil, err
+		}
+		if document, _, err = removePointer(document, path); err != nil {
+			return nil, err
+		}
+		return addPointer(document, path, normalizeJSON(operation.Value, processHashKey))
+	case "move", "copy":
+		from, err := parsePointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if operation.Op == "move" {
+			document, value, err = removePointer(document, from)
+		} else {
+			value, err = getPointer(document, from)
+			value = normalizeJSON(value, processHashKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return addPointer(document, path, value)
+	case "test":
+		value, err := getPointer(document, path)
+		if err != nil {
+			return nil, err
+		}
+		expected, _ := json.Marshal(normalizeJSON(operation.Value, processHashKey))
+		actual, _ := json.Marshal(value)
+		if string(expected) != string(actual) {
+			return nil, errors.New("test failed")
+		}
+		return document, nil
+	default:
+		return nil, errors.New("unknown operation")
+	}
+}
+
+func getPointer(document interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := document.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			document = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			document = node[index]
+		default:
+			return nil, fmt.Errorf("can not select %q of a %s", token, jsonType(document))
+		}
+	}
+	return document, nil
+}
+
+// To add the value at the path, returning the updated document.
+func addPointer(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return updatePointer(document, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			index := len(node)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(node)); err != nil {
+					return nil, err
+				}
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		default:
+			return nil, fmt.Errorf("can not add %q to a %s", token, jsonType(parent))
+		}
+	})
+}
+
+// To remove the value at the path, returning the updated document and the removed value.
+func removePointer(document interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, document, nil
+	}
+
+	var removed interface{}
+	document, err := updatePointer(document, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			removed = value
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[index]
+			return append(node[:index], node[index+1:]...), nil
+		default:
+			return nil, fmt.Errorf("can not remove %q from a %s", token, jsonType(parent))
+		}
+	})
+	return document, removed, err
+}
+
+// To update the parent of the last token of the path, and set the updated parent back into the document.
+func updatePointer(document interface{}, path []string, update func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return update(document, path[0])
+	}
+
+	child, err := getPointer(document, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = updatePointer(child, path[1:], update); err != nil {
+		return nil, err
+	}
+
+	switch node := document.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		index, _ := arrayIndex(path[0], len(node)-1)
+		node[index] = child
+	}
+	return document, nil
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return index, nil
+}
+
+// ApplyMergePatch applies an RFC 7386 JSON Merge Patch to a copy of the document.
+func ApplyMergePatch(document interface{}, patch interface{}) interface{} {
+	return applyMergePatch(normalizeJSON(document, processHashKey), normalizeJSON(patch, processHashKey))
+}
+
+func applyMergePatch(document interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	object, ok := document.(map[string]interface{})
+	if !ok {
+		object = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(object, key)
+			continue
+		}
+		object[key] = applyMergePatch(object[key], value)
+	}
+	return object
+}
+
+// ErrActivityNotApplicable is returned by Reconstruct for an activity that changed the entity
+// without recording a patch or its DataAfter, e.g. with IsDiffOnly and no PatchFormat.
+var ErrActivityNotApplicable = errors.New("activity can not be applied")
+
+// Reconstruct rebuilds an entity as it was at the given time, by applying the patches of the
+// activities to the base document in timestamp order. The match func selects the activities
+// of the entity, e.g. by resource and target, every activity is applied when it is nil.
+// Activities without a patch replace the entity with their DataAfter, and activities with
+// only a DataBefore delete it, the result is nil. Activities without data are skipped.
+//
+// The recorded patches and data are masked by the masking rules and PII detection,
+// so the rebuilt entity holds the masked or hashed values, not the original ones.
+func Reconstruct(base interface{}, transactions []*Transaction, at time.Time, match func(tx *Transaction, activity *Activity) bool) (interface{}, error) {
+	var activities []*Activity
+	for _, tx := range transactions {
+		for j := range tx.Activities {
+			activity := &tx.Activities[j]
+			if activity.Timestamp.After(at) || (match != nil && !match(tx, activity)) {
+				continue
+			}
+			activities = append(activities, activity)
+		}
+	}
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activities[i].Timestamp.Before(activities[j].Timestamp)
+	})
+
+	document := normalizeJSON(base, processHashKey)
+	for _, activity := range activities {
+		switch {
+		case activity.JSONPatch != nil:
+			var err error
+			if document, err = ApplyJSONPatch(document, activity.JSONPatch); err != nil {
+				return nil, fmt.Errorf("activity %s at %s: %w", activity.Action, activity.Timestamp.Format(time.RFC3339Nano), err)
+			}
+		case activity.MergePatch != nil:
+			document = ApplyMergePatch(document, activity.MergePatch)
+		case activity.DataAfter != nil:
+			document = normalizeJSON(activity.DataAfter, processHashKey)
+		case activity.DataBefore != nil:
+			document = nil
+		case len(activity.Changes) != 0:
+			return nil, fmt.Errorf("activity %s at %s: %w", activity.Action, activity.Timestamp.Format(time.RFC3339Nano), ErrActivityNotApplicable)
+		}
+	}
+	return document, nil
+}
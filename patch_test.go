@@ -0,0 +1,183 @@
+package audittrail
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSegmentEndComputesPatches(t *testing.T) {
+	before := map[string]interface{}{
+		"status": "pending",
+		"a/b":    1,
+		"items":  []interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2}, map[string]interface{}{"id": 3}},
+		"note":   "call back",
+	}
+	after := map[string]interface{}{
+		"status":   "approved",
+		"a/b":      1,
+		"items":    []interface{}{map[string]interface{}{"id": 1}},
+		"approver": map[string]interface{}{"name": "Budi"},
+		"reason":   nil,
+	}
+
+	log := &Transaction{patchFormat: PatchFormatBoth}
+	log.StartAction("approve", "approve loan").SetDataBefore(before).SetDataAfter(after).End()
+	activity := log.Activities[0]
+
+	expected := []PatchOperation{
+		{Op: "add", Path: "/approver", Value: map[string]interface{}{"name": "Budi"}},
+		{Op: "remove", Path: "/items/2"},
+		{Op: "remove", Path: "/items/1"},
+		{Op: "remove", Path: "/note"},
+		{Op: "add", Path: "/reason", Value: nil},
+		{Op: "replace", Path: "/status", Value: "approved"},
+	}
+	if !reflect.DeepEqual(activity.JSONPatch, expected) {
+		t.Errorf("Expected JSON patch to be %+v, but got %+v", expected, activity.JSONPatch)
+	}
+
+	patched, err := ApplyJSONPatch(before, activity.JSONPatch)
+	if err != nil {
+		t.Fatalf("Error applying JSON patch: %v", err)
+	}
+	if !reflect.DeepEqual(patched, normalizeJSON(after, processHashKey)) {
+		t.Errorf("Expected the JSON patch to rebuild %v, but got %v", after, patched)
+	}
+
+	merged := ApplyMergePatch(before, activity.MergePatch)
+	if merged.(map[string]interface{})["status"] != "approved" || merged.(map[string]interface{})["note"] != nil {
+		t.Errorf("Expected the merge patch to be applied, but got %v", merged)
+	}
+
+	payload, _ := json.Marshal(activity.JSONPatch[4])
+	if string(payload) != `{"op":"add","path":"/reason","value":null}` {
+		t.Errorf("Expected the null value to be kept, but got %s", payload)
+	}
+}
+
+func TestSegmentPatchFormatOverridesConfig(t *testing.T) {
+	log := &Transaction{patchFormat: PatchFormatBoth}
+	log.StartAction("update", "update").
+		SetDiffOptions(DiffOptions{PatchFormat: PatchFormatMergePatch}).
+		SetDataBefore(map[string]interface{}{"limit": 1}).
+		SetDataAfter(map[string]interface{}{"limit": 2}).
+		End()
+
+	activity := log.Activities[0]
+	if activity.JSONPatch != nil || activity.MergePatch == nil {
+		t.Errorf("Expected only a merge patch, but got %+v and %+v", activity.JSONPatch, activity.MergePatch)
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	document := map[string]interface{}{"a": []interface{}{"x", "y"}, "b": map[string]interface{}{"c": 1}}
+
+	result, err := ApplyJSONPatch(document, []PatchOperation{
+		{Op: "test", Path: "/b/c", Value: 1},
+		{Op: "add", Path: "/a/-", Value: "z"},
+		{Op: "add", Path: "/a/0", Value: "w"},
+		{Op: "move", From: "/b/c", Path: "/d"},
+		{Op: "copy", From: "/a/1", Path: "/e"},
+	})
+	if err != nil {
+		t.Fatalf("Error applying JSON patch: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"a": []interface{}{"w", "x", "y", "z"},
+		"b": map[string]interface{}{},
+		"d": json.Number("1"),
+		"e": "x",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected document to be %v, but got %v", expected, result)
+	}
+	if len(document["a"].([]interface{})) != 2 {
+		t.Errorf("Expected the document to be left untouched, but got %v", document)
+	}
+
+	if _, err := ApplyJSONPatch(document, []PatchOperation{{Op: "remove", Path: "/a/5"}}); err == nil {
+		t.Errorf("Expected removing a missing element to fail")
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var transactions []*Transaction
+	loans := []map[string]interface{}{
+		{"status": "pending", "amount": 1000},
+		{"status": "approved", "amount": 1000},
+		{"status": "disbursed", "amount": 1000},
+	}
+	for i := 1; i < len(loans); i++ {
+		log := &Transaction{Resource: "loan", patchFormat: PatchFormatJSONPatch}
+		log.StartAction("update", "update loan").SetDataBefore(loans[i-1]).SetDataAfter(loans[i]).End()
+		log.Activities[0].Timestamp = start.Add(time.Duration(i) * time.Hour)
+
+		consumed := &Transaction{}
+		json.Unmarshal(log.GetPayloadTransaction(), consumed)
+		transactions = append(transactions, consumed)
+	}
+	transactions[0], transactions[1] = transactions[1], transactions[0]
+
+	isLoan := func(tx *Transaction, activity *Activity) bool { return tx.Resource == "loan" }
+
+	result, err := Reconstruct(loans[0], transactions, start.Add(90*time.Minute), isLoan)
+	if err != nil {
+		t.Fatalf("Error reconstructing: %v", err)
+	}
+	if status := result.(map[string]interface{})["status"]; status != "approved" {
+		t.Errorf("Expected status to be %s, but got %v", "approved", status)
+	}
+
+	result, _ = Reconstruct(loans[0], transactions, start.Add(3*time.Hour), isLoan)
+	if status := result.(map[string]interface{})["status"]; status != "disbursed" {
+		t.Errorf("Expected status to be %s, but got %v", "disbursed", status)
+	}
+}
+
+func TestSanitizerMasksPatches(t *testing.T) {
+	log := &Transaction{patchFormat: PatchFormatBoth}
+	log.StartAction("update", "update").
+		SetDataBefore(map[string]interface{}{"customer": map[string]interface{}{"nik": "3171234567890001"}}).
+		SetDataAfter(map[string]interface{}{"customer": map[string]interface{}{"nik": "3171234567890002"}}).
+		End()
+
+	log.sanitizer = newSanitizer(ActivityLogConfig{
+		MaskingRules: []MaskingRule{{Path: "$.customer.nik", Strategy: MaskStrategyPartial}},
+	})
+	payload := string(log.GetPayloadTransaction())
+
+	if strings.Contains(payload, "3171234567890002") {
+		t.Errorf("Expected the patched nik to be masked, but got %s", payload)
+	}
+	if !strings.Contains(payload, `"jsonPatch":[{"op":"replace","path":"/customer/nik"`) {
+		t.Errorf("Expected payload to contain the JSON patch, but got %s", payload)
+	}
+}
+
+func TestReconstructDeleteAndDiffOnly(t *testing.T) {
+	loan := map[string]interface{}{"status": "pending"}
+
+	deleted := &Transaction{}
+	deleted.StartAction("delete", "delete loan").SetDataBefore(loan).End()
+	result, err := Reconstruct(loan, []*Transaction{deleted}, time.Now(), nil)
+	if err != nil || result != nil {
+		t.Errorf("Expected the deleted loan to be nil, but got %v %v", result, err)
+	}
+
+	diffOnly := &Transaction{}
+	diffOnly.StartAction("update", "update loan").
+		SetDiffOptions(DiffOptions{IsDiffOnly: true}).
+		SetDataBefore(loan).
+		SetDataAfter(map[string]interface{}{"status": "approved"}).
+		End()
+	if _, err := Reconstruct(loan, []*Transaction{diffOnly}, time.Now(), nil); !errors.Is(err, ErrActivityNotApplicable) {
+		t.Errorf("Expected %v, but got %v", ErrActivityNotApplicable, err)
+	}
+}
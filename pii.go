@@ -0,0 +1,227 @@
+package audittrail
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PIIDetector is used to find personal data inside free-text values.
+type PIIDetector interface {
+
+	// Name returns the type of the personal data found by the detector, e.g. "card".
+	Name() string
+
+	// Detect returns the [start, end) byte offsets of the personal data found in the value.
+	Detect(value string) [][]int
+}
+
+// PIISummary is used to tell downstream consumers that personal data was found and masked.
+type PIISummary struct {
+
+	// Types is used to store the number of matches per detector name.
+	Types map[string]int `json:"types"`
+
+	// Paths is used to store the location of the masked values, e.g. "requestBody.notes".
+	Paths []string `json:"paths"`
+}
+
+type regexPIIDetector struct {
+	name     string
+	pattern  *regexp.Regexp
+	group    int
+	validate func(match string) bool
+}
+
+// NewRegexPIIDetector creates a PIIDetector from a regular expression.
+// When validate is not nil, only the matches it accepts are reported.
+func NewRegexPIIDetector(name string, pattern *regexp.Regexp, validate func(match string) bool) PIIDetector {
+	return &regexPIIDetector{name: name, pattern: pattern, validate: validate}
+}
+
+func (d *regexPIIDetector) Name() string {
+	return d.name
+}
+
+func (d *regexPIIDetector) Detect(value string) [][]int {
+	var found [][]int
+	for _, match := range d.pattern.FindAllStringSubmatchIndex(value, -1) {
+		start, end := match[2*d.group], match[2*d.group+1]
+		if start < 0 {
+			continue
+		}
+		if d.validate != nil && !d.validate(value[start:end]) {
+			continue
+		}
+		found = append(found, []int{start, end})
+	}
+	return found
+}
+
+var (
+	cardPattern        = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	nikPattern         = regexp.MustCompile(`\b\d{16}\b`)
+	phonePattern       = regexp.MustCompile(`(?:\+[1-9]\d{7,14}|\b08[1-9]\d{7,10})\b`)
+	emailPattern       = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	bankAccountPattern = regexp.MustCompile(`(?i)\b(?:no\.?\s*rek(?:ening)?|rekening|rek\.|account\s*(?:number|no\.?)|acc\.?\s*no\.?)\s*[:#]?\s*(\d[\d -]{6,22}\d)`)
+)
+
+// CardPIIDetector finds payment card numbers (PAN) that pass the Luhn check.
+func CardPIIDetector() PIIDetector {
+	return NewRegexPIIDetector("card", cardPattern, func(match string) bool {
+		digits := stripSeparators(match)
+		return len(digits) >= 13 && len(digits) <= 19 && luhnValid(digits)
+	})
+}
+
+// NIKPIIDetector finds Indonesian national ID numbers (NIK) with a valid region and birth date.
+func NIKPIIDetector() PIIDetector {
+	return NewRegexPIIDetector("nik", nikPattern, nikValid)
+}
+
+// PhonePIIDetector finds E.164 phone numbers and Indonesian local mobile numbers.
+func PhonePIIDetector() PIIDetector {
+	return NewRegexPIIDetector("phone", phonePattern, nil)
+}
+
+// EmailPIIDetector finds email addresses.
+func EmailPIIDetector() PIIDetector {
+	return NewRegexPIIDetector("email", emailPattern, nil)
+}
+
+// BankAccountPIIDetector finds bank account numbers that follow an account keyword,
+// e.g. "no rek: 1234567890" or "account number 1234567890".
+func BankAccountPIIDetector() PIIDetector {
+	return &regexPIIDetector{name: "bankAccount", pattern: bankAccountPattern, group: 1}
+}
+
+// DefaultPIIDetectors returns the detectors used when PIIDetectors is not configured.
+func DefaultPIIDetectors() []PIIDetector {
+	return []PIIDetector{
+		CardPIIDetector(),
+		NIKPIIDetector(),
+		BankAccountPIIDetector(),
+		PhonePIIDetector(),
+		EmailPIIDetector(),
+	}
+}
+
+func stripSeparators(value string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(value)
+}
+
+// To check the number with the Luhn algorithm.
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// To check the NIK format: PPKKCC DDMMYY SSSS, where women add 40 to the birth day.
+func nikValid(nik string) bool {
+	province, _ := strconv.Atoi(nik[0:2])
+	day, _ := strconv.Atoi(nik[6:8])
+	month, _ := strconv.Atoi(nik[8:10])
+	sequence := nik[12:16]
+
+	if province < 11 || province > 94 {
+		return false
+	}
+	if day > 40 {
+		day -= 40
+	}
+	if day < 1 || day > 31 || month < 1 || month > 12 {
+		return false
+	}
+	return sequence != "0000"
+}
+
+// To mask the personal data found in the value.
+// It returns the masked value and the number of matches per detector.
+func detectPII(detectors []PIIDetector, value string) (string, map[string]int) {
+	var found map[string]int
+	for _, detector := range detectors {
+		matches := detector.Detect(value)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var b strings.Builder
+		last := 0
+		for _, match := range matches {
+			b.WriteString(value[last:match[0]])
+			b.WriteString(maskPartial(value[match[0]:match[1]]))
+			last = match[1]
+		}
+		b.WriteString(value[last:])
+		value = b.String()
+
+		if found == nil {
+			found = make(map[string]int)
+		}
+		found[detector.Name()] += len(matches)
+	}
+	return value, found
+}
+
+// To scan every string of the decoded JSON document and mask the personal data found.
+func (s *sanitizer) scanPII(node interface{}, path string, summary *PIISummary) interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = s.scanPII(value, path+"."+key, summary)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = s.scanPII(value, path+"["+strconv.Itoa(i)+"]", summary)
+		}
+		return v
+	case string:
+		masked, found := detectPII(s.detectors, v)
+		if found == nil {
+			return v
+		}
+		summary.add(path, found)
+		return masked
+	case json.Number:
+		masked, found := detectPII(s.detectors, v.String())
+		if found == nil {
+			return v
+		}
+		summary.add(path, found)
+		return masked
+	default:
+		return v
+	}
+}
+
+// To mask the personal data found in a body that could not be decoded.
+func (s *sanitizer) scanRawPII(raw *RawBody, path string, summary *PIISummary) {
+	if raw == nil || raw.Encoding != "text" {
+		return
+	}
+	raw.Data = s.scanPII(raw.Data, path+".data", summary).(string)
+}
+
+func (p *PIISummary) add(path string, found map[string]int) {
+	if p.Types == nil {
+		p.Types = make(map[string]int)
+	}
+	for name, count := range found {
+		p.Types[name] += count
+	}
+	p.Paths = append(p.Paths, path)
+}
@@ -0,0 +1,75 @@
+package audittrail
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPIIDetectors(t *testing.T) {
+	tests := []struct {
+		detector PIIDetector
+		value    string
+		expected []string
+	}{
+		{CardPIIDetector(), "paid with 4111 1111 1111 1111 yesterday", []string{"4111 1111 1111 1111"}},
+		{CardPIIDetector(), "order 4111111111111112", nil},
+		{NIKPIIDetector(), "nik saya 3201234507900001", []string{"3201234507900001"}},
+		{NIKPIIDetector(), "nik saya 9901234507900001", nil},
+		{PhonePIIDetector(), "call +6281234567890 or 081234567890", []string{"+6281234567890", "081234567890"}},
+		{EmailPIIDetector(), "mail budi.santoso@example.co.id please", []string{"budi.santoso@example.co.id"}},
+		{BankAccountPIIDetector(), "transfer ke no rek: 1234567890 ya", []string{"1234567890"}},
+	}
+
+	for _, test := range tests {
+		var found []string
+		for _, match := range test.detector.Detect(test.value) {
+			found = append(found, test.value[match[0]:match[1]])
+		}
+		if !reflect.DeepEqual(found, test.expected) {
+			t.Errorf("Expected %s to find %v in %q, but got %v", test.detector.Name(), test.expected, test.value, found)
+		}
+	}
+}
+
+func TestSanitizerDetectsPII(t *testing.T) {
+	transaction := &Transaction{
+		RequestBody: map[string]interface{}{
+			"notes": "my card is 4111111111111111",
+			"name":  "Budi",
+		},
+		sanitizer: newSanitizer(ActivityLogConfig{IsDetectPII: true}),
+	}
+
+	segment := transaction.StartAction("update", "update profile")
+	segment.SetRequestData(map[string]interface{}{"comment": "email me at budi@example.com"})
+	segment.End()
+
+	var result Transaction
+	if err := json.Unmarshal(transaction.GetPayloadTransaction(), &result); err != nil {
+		t.Fatalf("Error decoding payload: %v", err)
+	}
+
+	if notes := result.RequestBody["notes"].(string); strings.Contains(notes, "4111111111111111") || !strings.HasSuffix(notes, "1111") {
+		t.Errorf("Expected card number to be masked, but got %s", notes)
+	}
+
+	if comment := result.Activities[0].RequestData.(map[string]interface{})["comment"].(string); strings.Contains(comment, "budi@") {
+		t.Errorf("Expected email to be masked, but got %s", comment)
+	}
+
+	if result.PIIDetected == nil {
+		t.Fatalf("Expected PIIDetected to be set, but it was not")
+	}
+
+	expectedTypes := map[string]int{"card": 1, "email": 1}
+	if !reflect.DeepEqual(result.PIIDetected.Types, expectedTypes) {
+		t.Errorf("Expected PIIDetected types to be %v, but got %v", expectedTypes, result.PIIDetected.Types)
+	}
+
+	expectedPaths := []string{"activities[0].requestData.comment", "requestBody.notes"}
+	if !reflect.DeepEqual(result.PIIDetected.Paths, expectedPaths) {
+		t.Errorf("Expected PIIDetected paths to be %v, but got %v", expectedPaths, result.PIIDetected.Paths)
+	}
+}
@@ -0,0 +1,140 @@
+package audittrail
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// CallRecorder records the event logs of the calls served by a transport.
+// It is the core of NewHTTPMiddleware, and of the adapters of other transports,
+// e.g. the gRPC interceptors of the audittrailgrpc package.
+type CallRecorder struct {
+	publisher message.Publisher
+	cfg       ActivityLogConfig
+	headers   *headerRedactor
+	sanitizer *sanitizer
+	policies  routePolicies
+	proxies   trustedProxies
+	forwarded string
+}
+
+// NewCallRecorder creates the recorder of the calls of a transport.
+// It panics when cfg.TrustedProxies has an invalid entry, so misconfiguration is caught on startup.
+func NewCallRecorder(publisher message.Publisher, cfg ActivityLogConfig) *CallRecorder {
+	return &CallRecorder{
+		publisher: publisher,
+		cfg:       cfg,
+		headers:   newHeaderRedactor(cfg),
+		sanitizer: newSanitizer(cfg),
+		policies:  newRoutePolicies(cfg.RoutePolicies),
+		proxies:   newTrustedProxies(cfg.TrustedProxies),
+		forwarded: forwardedHeader(cfg),
+	}
+}
+
+// Call is used to store the event log of a call being recorded, see CallRecorder.Start.
+type Call struct {
+
+	// Log is used to store the event log of the call.
+	// The handler gets it from its context, see NewContext.
+	Log *Transaction
+
+	// Config is used to store the config of the call, with its route policy applied.
+	Config ActivityLogConfig
+
+	recorder     *CallRecorder
+	start        time.Time
+	handlerStart time.Time
+	handlerEnd   time.Time
+}
+
+// Start creates the event log of the call described by r, e.g. a gRPC call with its metadata as the header
+// and its peer as the remote address. The route policies are matched against the method of r and the pattern,
+// which is also the target of the event log. It returns nil when the route policy skips the call.
+func (c *CallRecorder) Start(r *http.Request, pattern string) *Call {
+	// The total latency includes the actor resolution and the token verification
+	start := time.Now()
+
+	policy := c.policies.match(r.Method, pattern)
+	if policy != nil && policy.Skip {
+		return nil
+	}
+	cfg := policy.apply(c.cfg)
+
+	log := createTransactionLog(cfg, c.sanitizer, c.headers, c.proxies, r, pattern)
+	log.Client = c.proxies.clientInfo(r, c.forwarded)
+	if policy != nil {
+		log.EventType = policy.EventType
+		log.Resource = policy.Resource
+		log.Type = policy.Type
+	}
+
+	log.Start()
+	return &Call{Log: log, Config: cfg, recorder: c, start: start}
+}
+
+// RunHandler calls the handler of the call, and measures its latency apart from the recording.
+// The handler end is taken even when the handler panics.
+func (c *Call) RunHandler(handler func()) {
+	c.handlerStart = time.Now()
+	defer func() {
+		c.handlerEnd = time.Now()
+	}()
+	handler()
+}
+
+// RequestBodyLimit returns the number of request body bytes kept for the event log, zero when the request body is not recorded.
+func (c *Call) RequestBodyLimit() int64 {
+	if !c.Config.IsRecordRequestBody {
+		return 0
+	}
+	return requestBodyLimit(c.Config)
+}
+
+// ResponseBodyLimit returns the number of response body bytes kept for the event log, zero when the response body is not recorded.
+func (c *Call) ResponseBodyLimit() int64 {
+	return responseBodyLimit(c.Config)
+}
+
+// SetRequestBody records the first bytes of the request body, size is the size of the whole body.
+func (c *Call) SetRequestBody(contentType string, data []byte, size int64, truncated bool) {
+	c.Log.RequestBodySize = size
+	c.Log.RequestBodyTruncated = truncated
+	c.Log.RequestBody, c.Log.RequestBodyValue, c.Log.RequestBodyRaw = parseBody(contentType, data, truncated)
+}
+
+// SetResponseBody records the first bytes of the response body, size is the size of the whole body.
+func (c *Call) SetResponseBody(contentType string, data []byte, size int64, truncated bool) {
+	c.Log.ResponseBodySize = size
+	c.Log.ResponseBodyTruncated = truncated
+	c.Log.ResponseBody, c.Log.ResponseBodyValue, c.Log.ResponseBodyRaw = decodeBody(contentType, data, truncated)
+}
+
+// Finish records the HTTP response code of the call and publishes its event log.
+// It is deferred by the adapter, which calls the handler through RunHandler, recovered is the value the handler panicked with, if any.
+// A panic is recorded with a 500 response code, and the adapter panics again after Finish.
+func (c *Call) Finish(ctx context.Context, responseCode int, recovered interface{}) {
+	log := c.Log
+	if !c.handlerEnd.IsZero() {
+		log.HandlerLatencyMs = durationMs(c.handlerEnd.Sub(c.handlerStart))
+	}
+
+	if c.Config.IsRecordResponseCode {
+		log.ResponseCode = responseCode
+	}
+	if recovered != nil {
+		log.Status = TransactionStatusFailed
+		log.Error = newPanicError(recovered, 1)
+		log.ResponseCode = http.StatusInternalServerError
+	}
+
+	log.End()
+	log.TotalLatencyMs = durationMs(log.TimeEnd.Sub(c.start))
+
+	if recovered != nil || log.hasActivities() || c.Config.IsPublishWhenNoActivities {
+		PublishLog(ctx, c.recorder.publisher, log, c.Config.TopicName)
+	}
+}
@@ -0,0 +1,262 @@
+package audittrail
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// responseWriter records the response of the handler for the event log.
+// It is never handed to the handler directly, see wrapResponseWriter.
+type responseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+
+	// wroteHeader is set once the final status code is sent.
+	wroteHeader bool
+
+	// limit is the number of body bytes kept for the event log, zero disables the capture.
+	limit int64
+
+	// size is the number of body bytes written to the client.
+	size int64
+
+	// truncated is set when the body is larger than the limit.
+	truncated bool
+
+	// streaming is set once the handler flushes, hijacks or sends an event stream.
+	// From then on only the byte count is recorded.
+	streaming bool
+}
+
+// rwUnwrapper lets http.ResponseController reach the underlying writer.
+type rwUnwrapper interface {
+	Unwrap() http.ResponseWriter
+}
+
+// To wrap the writer so the response is recorded, while exposing exactly
+// the optional interfaces (Flusher, Hijacker, Pusher, ReaderFrom) of the underlying writer.
+func wrapResponseWriter(w http.ResponseWriter, limit int64) (*responseWriter, http.ResponseWriter) {
+	rw := &responseWriter{ResponseWriter: w, limit: limit}
+
+	_, isFlusher := w.(http.Flusher)
+	_, isHijacker := w.(http.Hijacker)
+	_, isPusher := w.(http.Pusher)
+	_, isReaderFrom := w.(io.ReaderFrom)
+
+	type base interface {
+		http.ResponseWriter
+		rwUnwrapper
+	}
+
+	switch {
+	case isFlusher && isHijacker && isPusher && isReaderFrom:
+		return rw, struct {
+			base
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{rw, rw, rw, rw, rw}
+	case isFlusher && isHijacker && isPusher:
+		return rw, struct {
+			base
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{rw, rw, rw, rw}
+	case isFlusher && isHijacker && isReaderFrom:
+		return rw, struct {
+			base
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, rw, rw, rw}
+	case isFlusher && isPusher && isReaderFrom:
+		return rw, struct {
+			base
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{rw, rw, rw, rw}
+	case isHijacker && isPusher && isReaderFrom:
+		return rw, struct {
+			base
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{rw, rw, rw, rw}
+	case isFlusher && isHijacker:
+		return rw, struct {
+			base
+			http.Flusher
+			http.Hijacker
+		}{rw, rw, rw}
+	case isFlusher && isPusher:
+		return rw, struct {
+			base
+			http.Flusher
+			http.Pusher
+		}{rw, rw, rw}
+	case isFlusher && isReaderFrom:
+		return rw, struct {
+			base
+			http.Flusher
+			io.ReaderFrom
+		}{rw, rw, rw}
+	case isHijacker && isPusher:
+		return rw, struct {
+			base
+			http.Hijacker
+			http.Pusher
+		}{rw, rw, rw}
+	case isHijacker && isReaderFrom:
+		return rw, struct {
+			base
+			http.Hijacker
+			io.ReaderFrom
+		}{rw, rw, rw}
+	case isPusher && isReaderFrom:
+		return rw, struct {
+			base
+			http.Pusher
+			io.ReaderFrom
+		}{rw, rw, rw}
+	case isFlusher:
+		return rw, struct {
+			base
+			http.Flusher
+		}{rw, rw}
+	case isHijacker:
+		return rw, struct {
+			base
+			http.Hijacker
+		}{rw, rw}
+	case isPusher:
+		return rw, struct {
+			base
+			http.Pusher
+		}{rw, rw}
+	case isReaderFrom:
+		return rw, struct {
+			base
+			io.ReaderFrom
+		}{rw, rw}
+	default:
+		return rw, struct {
+			base
+		}{rw}
+	}
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	if !rw.wroteHeader {
+		rw.statusCode = statusCode
+		// Informational responses are followed by the final status code.
+		rw.wroteHeader = statusCode >= 200 || statusCode == http.StatusSwitchingProtocols
+	}
+	if strings.HasPrefix(rw.Header().Get("Content-Type"), "text/event-stream") {
+		rw.startStreaming()
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *responseWriter) Write(body []byte) (int, error) {
+	rw.implicitHeader(http.StatusOK)
+	n, err := rw.ResponseWriter.Write(body)
+	rw.record(body[:n])
+	return n, err
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *responseWriter) Flush() {
+	rw.implicitHeader(http.StatusOK)
+	rw.startStreaming()
+	rw.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.implicitHeader(http.StatusSwitchingProtocols)
+	rw.startStreaming()
+	return rw.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func (rw *responseWriter) Push(target string, opts *http.PushOptions) error {
+	return rw.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// To keep the io.Copy fast path of the underlying writer, e.g. sendfile for an *os.File.
+// The source is only teed up to the capture limit, the rest is handed to the underlying writer as is.
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	rw.implicitHeader(http.StatusOK)
+	rf := rw.ResponseWriter.(io.ReaderFrom)
+
+	var captured int64
+	if remaining := rw.limit - int64(rw.body.Len()); !rw.streaming && remaining > 0 {
+		n, err := rf.ReadFrom(io.TeeReader(io.LimitReader(src, remaining), recordWriter{rw}))
+		if err != nil || n < remaining {
+			return n, err
+		}
+		captured = n
+	}
+
+	n, err := rf.ReadFrom(src)
+	rw.size += n
+	if n > 0 && !rw.streaming && rw.limit > 0 {
+		rw.truncated = true
+	}
+	return captured + n, err
+}
+
+// To record the status code the server sends when the handler does not call WriteHeader.
+func (rw *responseWriter) implicitHeader(statusCode int) {
+	if !rw.wroteHeader {
+		rw.statusCode = statusCode
+		rw.wroteHeader = true
+	}
+}
+
+// To get the status code received by the client.
+// A handler that neither writes nor calls WriteHeader still responds with 200.
+func (rw *responseWriter) effectiveStatusCode() int {
+	if !rw.wroteHeader {
+		return http.StatusOK
+	}
+	return rw.statusCode
+}
+
+// To record the bytes written to the client.
+func (rw *responseWriter) record(body []byte) {
+	rw.size += int64(len(body))
+	if rw.streaming || rw.limit <= 0 {
+		return
+	}
+
+	if remaining := rw.limit - int64(rw.body.Len()); int64(len(body)) > remaining {
+		rw.truncated = true
+		body = body[:remaining]
+	}
+	rw.body.Write(body)
+}
+
+// To stop buffering the body, only the byte count is recorded from now on.
+func (rw *responseWriter) startStreaming() {
+	rw.streaming = true
+	rw.body = bytes.Buffer{}
+}
+
+type recordWriter struct {
+	rw *responseWriter
+}
+
+func (w recordWriter) Write(body []byte) (int, error) {
+	w.rw.record(body)
+	return len(body), nil
+}
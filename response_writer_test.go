@@ -0,0 +1,132 @@
+package audittrail
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fullResponseWriter struct {
+	*httptest.ResponseRecorder
+	pushed   string
+	readFrom bool
+	sources  []io.Reader
+}
+
+func (w *fullResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+func (w *fullResponseWriter) Push(target string, opts *http.PushOptions) error {
+	w.pushed = target
+	return nil
+}
+
+func (w *fullResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.readFrom = true
+	w.sources = append(w.sources, src)
+	return io.Copy(w.ResponseRecorder, src)
+}
+
+func TestWrapResponseWriterInterfaces(t *testing.T) {
+	t.Run("exposes only the interfaces of the underlying writer", func(t *testing.T) {
+		_, w := wrapResponseWriter(httptest.NewRecorder(), 0)
+
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("Expected writer to implement http.Flusher")
+		}
+
+		if _, ok := w.(http.Hijacker); ok {
+			t.Errorf("Expected writer not to implement http.Hijacker")
+		}
+
+		if _, ok := w.(http.Pusher); ok {
+			t.Errorf("Expected writer not to implement http.Pusher")
+		}
+
+		if _, ok := w.(io.ReaderFrom); ok {
+			t.Errorf("Expected writer not to implement io.ReaderFrom")
+		}
+	})
+
+	t.Run("delegates every interface of the underlying writer", func(t *testing.T) {
+		underlying := &fullResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+		rw, w := wrapResponseWriter(underlying, 1024)
+
+		if err := w.(http.Pusher).Push("/style.css", nil); err != nil || underlying.pushed != "/style.css" {
+			t.Errorf("Expected Push to be delegated, but got %v", err)
+		}
+
+		n, err := w.(io.ReaderFrom).ReadFrom(strings.NewReader(`{"message":"success"}`))
+		if err != nil || !underlying.readFrom {
+			t.Errorf("Expected ReadFrom to be delegated, but got %v", err)
+		}
+
+		if rw.size != n || rw.body.String() != `{"message":"success"}` {
+			t.Errorf("Expected ReadFrom to be recorded, but got %d bytes: %s", rw.size, rw.body.String())
+		}
+
+		if _, _, err := w.(http.Hijacker).Hijack(); err != nil || !rw.streaming {
+			t.Errorf("Expected Hijack to be delegated and to start streaming, but got %v", err)
+		}
+
+		if rc := http.NewResponseController(w); rc.Flush() != nil {
+			t.Errorf("Expected ResponseController to reach the underlying writer")
+		}
+	})
+
+	t.Run("records only byte counts once the response is flushed", func(t *testing.T) {
+		rw, w := wrapResponseWriter(httptest.NewRecorder(), 1024)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: second\n\n"))
+
+		if !rw.streaming {
+			t.Errorf("Expected writer to be streaming")
+		}
+
+		if rw.body.Len() != 0 {
+			t.Errorf("Expected body not to be buffered, but got %s", rw.body.String())
+		}
+
+		if rw.size != int64(len("data: first\n\ndata: second\n\n")) {
+			t.Errorf("Expected size to be %d, but got %d", len("data: first\n\ndata: second\n\n"), rw.size)
+		}
+	})
+
+	t.Run("truncates the captured body to the limit", func(t *testing.T) {
+		rw, w := wrapResponseWriter(httptest.NewRecorder(), 4)
+
+		w.Write([]byte("0123456789"))
+
+		if rw.body.String() != "0123" || !rw.truncated || rw.size != 10 {
+			t.Errorf("Expected body to be truncated to %s, but got %s", "0123", rw.body.String())
+		}
+	})
+
+	t.Run("hands the source past the limit to the underlying writer", func(t *testing.T) {
+		underlying := &fullResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+		rw, w := wrapResponseWriter(underlying, 4)
+
+		src := strings.NewReader("0123456789")
+		n, err := w.(io.ReaderFrom).ReadFrom(src)
+		if err != nil || n != 10 || underlying.Body.String() != "0123456789" {
+			t.Errorf("Expected %d bytes to be sent, but got %d: %v", 10, n, err)
+		}
+
+		if rw.body.String() != "0123" || !rw.truncated || rw.size != 10 {
+			t.Errorf("Expected body to be truncated to %s, but got %s", "0123", rw.body.String())
+		}
+
+		if len(underlying.sources) != 2 || underlying.sources[1] != io.Reader(src) {
+			t.Errorf("Expected the rest of the source to be read by the underlying writer as is, but got %v", underlying.sources)
+		}
+	})
+}
@@ -0,0 +1,283 @@
+package audittrail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// HTTPCall is used to store an outgoing HTTP call of an activity, e.g. to a vendor.
+type HTTPCall struct {
+
+	// Method is used to store the method of the call.
+	Method string `json:"method"`
+
+	// Host is used to store the host of the call.
+	Host string `json:"host"`
+
+	// Path is used to store the path template of the call, or the path when there is none.
+	Path string `json:"path"`
+
+	// StatusCode is used to store the response status code, 0 when no response was received.
+	StatusCode int `json:"statusCode,omitempty"`
+
+	// LatencyMs is used to store the duration of the call, in milliseconds.
+	LatencyMs float64 `json:"latencyMs"`
+
+	// Attempt is used to store the retry attempt of the call, see WithRetryAttempt.
+	Attempt int `json:"attempt,omitempty"`
+
+	// Error is used to store the transport error of the call.
+	Error string `json:"error,omitempty"`
+}
+
+// RoundTripperConfig is used to configure the RoundTripper of the outgoing calls.
+// The body flags and limits of the ActivityLogConfig apply to the call bodies,
+// and its service, topic and masking rules to the standalone vendor transactions.
+type RoundTripperConfig struct {
+	ActivityLogConfig
+
+	// Vendor is used to store the name of the called vendor, e.g. "pefindo".
+	// It is the action of the activities, the host is used when empty.
+	Vendor string
+}
+
+type contextKey string
+
+const (
+	pathTemplateCtx contextKey = "activity_log_path_template"
+	retryAttemptCtx contextKey = "activity_log_retry_attempt"
+)
+
+// WithPathTemplate sets the path template of the outgoing calls made with the context,
+// e.g. "/v1/reports/{id}", so the activities do not record the IDs of the path.
+func WithPathTemplate(ctx context.Context, template string) context.Context {
+	return context.WithValue(ctx, pathTemplateCtx, template)
+}
+
+// WithRetryAttempt sets the retry attempt of the outgoing calls made with the context, starting at 1.
+func WithRetryAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, retryAttemptCtx, attempt)
+}
+
+type roundTripper struct {
+	base      http.RoundTripper
+	publisher message.Publisher
+	cfg       RoundTripperConfig
+	sanitizer *sanitizer
+}
+
+// NewRoundTripper wraps base to record every outgoing call as an activity.
+// The activity is appended to the event log of the request context, nested in its innermost segment, see StartActionCtx.
+// Without an event log in the context, the call is published as a standalone vendor transaction,
+// or not recorded when publisher is nil.
+// With IsRecordResponseBody, the activity ends when the caller reads the response body to EOF or closes it.
+// Defaults to http.DefaultTransport when base is nil.
+func NewRoundTripper(base http.RoundTripper, publisher message.Publisher, cfg RoundTripperConfig) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &roundTripper{
+		base:      base,
+		publisher: publisher,
+		cfg:       cfg,
+		sanitizer: newSanitizer(cfg.ActivityLogConfig),
+	}
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	var requestBody []byte
+	var requestTruncated bool
+	if t.cfg.IsRecordRequestBody && req.Body != nil && req.Body != http.NoBody {
+		req, requestBody, requestTruncated = captureRequestBody(req, requestBodyLimit(t.cfg.ActivityLogConfig))
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+
+	call := &HTTPCall{
+		Method:    req.Method,
+		Host:      req.URL.Host,
+		Path:      req.URL.Path,
+		LatencyMs: durationMs(time.Since(start)),
+	}
+	if template, ok := ctx.Value(pathTemplateCtx).(string); ok && template != "" {
+		call.Path = template
+	}
+	if attempt, ok := ctx.Value(retryAttemptCtx).(int); ok {
+		call.Attempt = attempt
+	}
+	if err != nil {
+		call.Error = err.Error()
+	}
+
+	if resp != nil {
+		call.StatusCode = resp.StatusCode
+	}
+
+	action := t.cfg.Vendor
+	if action == "" {
+		action = call.Host
+	}
+
+	root := FromContext(ctx)
+	standalone := root == nil
+	if standalone {
+		if t.publisher == nil {
+			return resp, err
+		}
+		root = t.newVendorTransaction(req, start)
+	}
+
+	// Nest the call in the innermost segment of the context, see StartActionCtx
+	message := fmt.Sprintf("%s %s%s", call.Method, call.Host, call.Path)
+	var segment *Segment
+	if standalone {
+		segment = root.StartAction(action, message)
+	} else {
+		segment = startSegmentCtx(ctx, action, message)
+	}
+	segment.Activity.StartTime = start
+	segment.Activity.HTTP = call
+	if requestBody != nil {
+		segment.SetRequestData(t.sanitizer.redactRawData(bodyData(req.Header.Get("Content-Type"), requestBody, requestTruncated)))
+	}
+	if err != nil {
+		segment.Fail(err)
+	} else if call.StatusCode < http.StatusBadRequest {
+		segment.Succeed()
+	}
+
+	end := func(responseBody []byte, responseTruncated bool) {
+		if responseBody != nil {
+			segment.SetResponseData(t.sanitizer.redactRawData(bodyData(resp.Header.Get("Content-Type"), responseBody, responseTruncated)))
+		}
+		segment.End()
+
+		if standalone {
+			root.ResponseCode = call.StatusCode
+			root.TotalLatencyMs = call.LatencyMs
+			root.End()
+			PublishLog(ctx, t.publisher, root, t.cfg.TopicName)
+		}
+	}
+
+	// The response body is recorded while the caller reads it, so the activity ends when the body is closed
+	if resp != nil && resp.Body != nil && resp.Body != http.NoBody && t.cfg.IsRecordResponseBody {
+		resp.Body = &responseCapture{
+			bodyCapture: newBodyCapture(resp.Body, responseBodyLimit(t.cfg.ActivityLogConfig)),
+			end:         end,
+		}
+	} else {
+		end(nil, false)
+	}
+
+	return resp, err
+}
+
+// To create the transaction of a call made outside of an event log.
+func (t *roundTripper) newVendorTransaction(req *http.Request, start time.Time) *Transaction {
+	log := newTransaction(t.cfg.ActivityLogConfig, t.sanitizer)
+	log.EventType = t.cfg.Vendor
+	log.Target = fmt.Sprintf("%s %s%s", req.Method, req.URL.Host, req.URL.Path)
+	log.Type = "vendor"
+	log.TimeStart = start
+	return log
+}
+
+// To capture the first bytes of the request body without consuming it.
+// The body is read from GetBody when possible, otherwise its first bytes are put back in front of the rest.
+func captureRequestBody(req *http.Request, limit int64) (*http.Request, []byte, bool) {
+	if req.GetBody == nil {
+		clone := req.Clone(req.Context())
+		data, truncated := capturePrefix(&clone.Body, limit)
+		return clone, data, truncated
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return req, nil, false
+	}
+	defer body.Close()
+
+	data, truncated := capturePrefix(&body, limit)
+	return req, data, truncated
+}
+
+// To read the first bytes of the body, and put them back in front of the rest.
+func capturePrefix(body *io.ReadCloser, limit int64) ([]byte, bool) {
+	data, err := io.ReadAll(io.LimitReader(*body, limit+1))
+	*body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(data), *body), Closer: *body}
+	if err != nil {
+		return nil, false
+	}
+
+	if int64(len(data)) > limit {
+		return data[:limit], true
+	}
+	return data, false
+}
+
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// responseCapture passes the response body through to the caller,
+// and ends the activity of the call with the first bytes of it on EOF or Close, whichever comes first.
+type responseCapture struct {
+	*bodyCapture
+	once sync.Once
+	end  func(data []byte, truncated bool)
+}
+
+func (c *responseCapture) Read(p []byte) (int, error) {
+	n, err := c.bodyCapture.Read(p)
+	if err == io.EOF {
+		c.finish()
+	}
+	return n, err
+}
+
+func (c *responseCapture) Close() error {
+	err := c.bodyCapture.Close()
+	c.finish()
+	return err
+}
+
+// To end the activity once, with the part of the body the caller read.
+// A body closed before EOF is recorded as truncated.
+func (c *responseCapture) finish() {
+	c.once.Do(func() {
+		data := c.bytes()
+		if len(data) == 0 && !c.eof {
+			c.end(nil, false)
+			return
+		}
+		c.end(data, !c.eof || c.size > int64(len(data)))
+	})
+}
+
+// To decode a body of a call into the data of the activity.
+func bodyData(contentType string, data []byte, truncated bool) interface{} {
+	object, value, raw := decodeBody(contentType, data, truncated)
+	switch {
+	case object != nil:
+		return object
+	case value != nil:
+		return value
+	case raw != nil:
+		return raw
+	default:
+		return nil
+	}
+}
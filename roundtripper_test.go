@@ -0,0 +1,177 @@
+package audittrail
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+)
+
+func newTestVendorServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(string(body), "unknown") {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte(`{"score":750,"nik":"3171234567890001"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRoundTripperAppendsActivity(t *testing.T) {
+	server := newTestVendorServer(t)
+	client := &http.Client{Transport: NewRoundTripper(nil, nil, RoundTripperConfig{
+		ActivityLogConfig: ActivityLogConfig{IsRecordRequestBody: true, IsRecordResponseBody: true},
+		Vendor:            "pefindo",
+	})}
+
+	log := &Transaction{}
+	ctx := NewContext(context.Background(), log)
+	ctx = WithPathTemplate(ctx, "/v1/reports/{id}")
+	ctx = WithRetryAttempt(ctx, 2)
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", server.URL+"/v1/reports/42", strings.NewReader(`{"customerId":"42"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error calling vendor: %v", err)
+	}
+	if len(log.Activities) != 0 {
+		t.Errorf("Expected the activity to end when the response body is closed, but got %+v", log.Activities)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != `{"score":750,"nik":"3171234567890001"}` {
+		t.Errorf("Expected the caller to read the whole response body, but got %s", body)
+	}
+
+	if len(log.Activities) != 1 {
+		t.Fatalf("Expected 1 activity, but got %d", len(log.Activities))
+	}
+	activity := log.Activities[0]
+
+	if activity.Action != "pefindo" || activity.Status != "success" {
+		t.Errorf("Expected a successful pefindo activity, but got %s %s", activity.Action, activity.Status)
+	}
+	call := activity.HTTP
+	if call.Method != "POST" || call.Path != "/v1/reports/{id}" || call.StatusCode != http.StatusOK || call.Attempt != 2 {
+		t.Errorf("Expected the call to be recorded, but got %+v", call)
+	}
+	if request, _ := activity.RequestData.(map[string]interface{}); request["customerId"] != "42" {
+		t.Errorf("Expected the request body to be recorded, but got %v", activity.RequestData)
+	}
+	if response, _ := activity.ResponseData.(map[string]interface{}); response["score"] == nil {
+		t.Errorf("Expected the response body to be recorded, but got %v", activity.ResponseData)
+	}
+}
+
+func TestRoundTripperPublishesStandaloneTransaction(t *testing.T) {
+	server := newTestVendorServer(t)
+	publisher := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	messages, _ := publisher.Subscribe(context.Background(), "vendorTopic")
+
+	client := &http.Client{Transport: NewRoundTripper(nil, publisher, RoundTripperConfig{
+		ActivityLogConfig: ActivityLogConfig{
+			ServiceName:          "testService",
+			TopicName:            "vendorTopic",
+			IsRecordResponseBody: true,
+			MaskingRules:         []MaskingRule{{Path: "$.nik", Strategy: MaskStrategyRemove}},
+		},
+		Vendor: "pefindo",
+	})}
+
+	resp, err := client.Post(server.URL+"/v1/reports", "application/json", strings.NewReader(`{"customerId":"unknown"}`))
+	if err != nil {
+		t.Fatalf("Error calling vendor: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	result := receiveTransaction(t, messages)
+	if result.Type != "vendor" || result.ResponseCode != http.StatusNotFound {
+		t.Errorf("Expected a vendor transaction with response code %d, but got %s %d", http.StatusNotFound, result.Type, result.ResponseCode)
+	}
+	if len(result.Activities) != 1 || result.Activities[0].Status != "failed" {
+		t.Fatalf("Expected 1 failed activity, but got %+v", result.Activities)
+	}
+	if response, _ := result.Activities[0].ResponseData.(map[string]interface{}); response["nik"] != nil {
+		t.Errorf("Expected nik to be masked, but got %v", response)
+	}
+}
+
+func TestRoundTripperRecordsResponseBodyClosedEarly(t *testing.T) {
+	server := newTestVendorServer(t)
+	client := &http.Client{Transport: NewRoundTripper(nil, nil, RoundTripperConfig{
+		ActivityLogConfig: ActivityLogConfig{IsRecordResponseBody: true},
+	})}
+
+	log := &Transaction{}
+	req, _ := http.NewRequestWithContext(NewContext(context.Background(), log), "GET", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error calling vendor: %v", err)
+	}
+	io.ReadFull(resp.Body, make([]byte, 10))
+	resp.Body.Close()
+	resp.Body.Close()
+
+	if len(log.Activities) != 1 {
+		t.Fatalf("Expected 1 activity, but got %d", len(log.Activities))
+	}
+	raw, _ := log.Activities[0].ResponseData.(*RawBody)
+	if raw == nil || !raw.Truncated {
+		t.Errorf("Expected the read part of the response body to be recorded as truncated, but got %+v", log.Activities[0].ResponseData)
+	}
+}
+
+func TestRoundTripperWithoutPublisher(t *testing.T) {
+	server := newTestVendorServer(t)
+	client := &http.Client{Transport: NewRoundTripper(nil, nil, RoundTripperConfig{
+		ActivityLogConfig: ActivityLogConfig{IsRecordResponseBody: true},
+	})}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Error calling vendor: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != `{"score":750,"nik":"3171234567890001"}` {
+		t.Errorf("Expected the call to pass through, but got %s", body)
+	}
+}
+
+func TestRoundTripperStandaloneTransactionRecordsErrorStack(t *testing.T) {
+	server := newTestVendorServer(t)
+	url := server.URL
+	server.Close()
+
+	publisher := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	messages, _ := publisher.Subscribe(context.Background(), "vendorTopic")
+
+	client := &http.Client{Transport: NewRoundTripper(nil, publisher, RoundTripperConfig{
+		ActivityLogConfig: ActivityLogConfig{
+			ServiceName:        "testService",
+			TopicName:          "vendorTopic",
+			IsRecordErrorStack: true,
+		},
+		Vendor: "pefindo",
+	})}
+
+	if _, err := client.Get(url); err == nil {
+		t.Fatalf("Expected the call to a closed server to fail")
+	}
+
+	result := receiveTransaction(t, messages)
+	if len(result.Activities) != 1 || result.Activities[0].Error == nil || result.Activities[0].Error.Stack == "" {
+		t.Errorf("Expected the failed activity to record its stack, but got %+v", result.Activities)
+	}
+}
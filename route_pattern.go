@@ -0,0 +1,78 @@
+package audittrail
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// RoutePatternResolver is used to resolve the route pattern of the request, e.g. "/api/v1/loans/{id}".
+// The pattern is used in the target of the event log and to match the route policies.
+// It returns an empty string when the request does not match a route.
+// The resolvers of other routers are in the audittrailchi (chi v5) and audittrailmux (gorilla/mux) packages.
+type RoutePatternResolver func(r *http.Request) string
+
+// To resolve the route pattern of the request.
+// The request path is used when the request does not match a route.
+func resolveRoutePattern(resolver RoutePatternResolver, r *http.Request) string {
+	if resolver == nil {
+		resolver = ServeMuxRoutePattern(nil)
+	}
+
+	if pattern := resolver(r); pattern != "" {
+		return pattern
+	}
+	return routePath(r)
+}
+
+// ServeMuxRoutePattern creates a RoutePatternResolver for the http.ServeMux patterns of Go 1.22.
+// The pattern of the request is used when the middleware wraps the handler of the route, with Go 1.23 or later,
+// otherwise the pattern is matched against mux, which may be nil.
+// The method of the pattern is left out, e.g. "GET /loans/{id}" resolves to "/loans/{id}".
+func ServeMuxRoutePattern(mux *http.ServeMux) RoutePatternResolver {
+	return func(r *http.Request) string {
+		pattern := requestPattern(r)
+		if pattern == "" && mux != nil {
+			_, pattern = mux.Handler(r)
+		}
+
+		if _, path, found := strings.Cut(pattern, " "); found {
+			pattern = strings.TrimSpace(path)
+		}
+		return pattern
+	}
+}
+
+// ChiRoutePattern resolves the route pattern of the chi router the request is served by.
+func ChiRoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+
+	if pattern := rctx.RoutePattern(); pattern != "" {
+		// Pattern is already available
+		return pattern
+	}
+	if rctx.Routes == nil {
+		return ""
+	}
+
+	// The middleware runs before the routing, so match the routes on a new context
+	tctx := chi.NewRouteContext()
+	if !rctx.Routes.Match(tctx, r.Method, routePath(r)) {
+		return ""
+	}
+
+	// tctx has the updated pattern, since Match mutates it
+	return tctx.RoutePattern()
+}
+
+// To get the path the routers match.
+func routePath(r *http.Request) string {
+	if r.URL.RawPath != "" {
+		return r.URL.RawPath
+	}
+	return r.URL.Path
+}
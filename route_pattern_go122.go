@@ -0,0 +1,11 @@
+//go:build !go1.23
+
+package audittrail
+
+import "net/http"
+
+// To get the pattern of the http.ServeMux route the request matched.
+// http.Request.Pattern is only available from Go 1.23, the mux is matched instead.
+func requestPattern(r *http.Request) string {
+	return ""
+}
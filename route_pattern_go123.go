@@ -0,0 +1,10 @@
+//go:build go1.23
+
+package audittrail
+
+import "net/http"
+
+// To get the pattern of the http.ServeMux route the request matched.
+func requestPattern(r *http.Request) string {
+	return r.Pattern
+}
@@ -0,0 +1,58 @@
+package audittrail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestNewHTTPMiddlewareRoutePattern(t *testing.T) {
+	var target string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		target = FromContext(r.Context()).Target
+	}
+
+	middleware := func(resolver RoutePatternResolver) func(http.Handler) http.Handler {
+		return NewHTTPMiddleware(nil, ActivityLogConfig{RoutePatternResolver: resolver})
+	}
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("GET /loans/{id}", handler)
+
+	perRouteMux := http.NewServeMux()
+	perRouteMux.Handle("GET /loans/{id}", middleware(nil)(http.HandlerFunc(handler)))
+
+	chiRouter := chi.NewRouter()
+	chiRouter.Use(NewActivityLogMiddleware(nil, ActivityLogConfig{})...)
+	chiRouter.Get("/loans/{id}", handler)
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"serve mux wrapped", middleware(ServeMuxRoutePattern(serveMux))(serveMux)},
+		{"serve mux per route", perRouteMux},
+		{"chi router", chiRouter},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target = ""
+			test.handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/loans/42", nil))
+
+			if target != "GET /loans/{id}" {
+				t.Errorf("Expected target to be %s, but got %s", "GET /loans/{id}", target)
+			}
+		})
+	}
+}
+
+func TestResolveRoutePatternFallsBackToPath(t *testing.T) {
+	r := httptest.NewRequest("GET", "/unknown/42", nil)
+
+	if pattern := resolveRoutePattern(ChiRoutePattern, r); pattern != "/unknown/42" {
+		t.Errorf("Expected pattern to be %s, but got %s", "/unknown/42", pattern)
+	}
+}
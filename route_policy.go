@@ -0,0 +1,149 @@
+package audittrail
+
+import (
+	"sort"
+	"strings"
+)
+
+// RoutePolicy is used to override the ActivityLogConfig for the matching routes.
+//
+// Example:
+//
+//	[]activitylog.RoutePolicy{
+//		{Pattern: "/health*", Skip: true},
+//		{Method: "GET", Pattern: "/api/v1/files/*", IsRecordResponseBody: activitylog.Bool(false)},
+//		{Method: "POST", Pattern: "/api/v1/loans/{id}/approve", EventType: "Approve Loan", Resource: "loan"},
+//	}
+type RoutePolicy struct {
+
+	// Method is used to store the HTTP method of the route. Empty or "*" matches any method.
+	Method string
+
+	// Pattern is used to store the route pattern, e.g. "/api/v1/users/{id}".
+	// A "*" matches any sequence of characters, slashes included.
+	Pattern string
+
+	// Skip is used to determine whether the route is not audited at all.
+	// FromContext returns nil for a skipped route, and the event log methods do nothing on it.
+	Skip bool
+
+	// IsRecordRequestBody overrides ActivityLogConfig.IsRecordRequestBody when not nil.
+	IsRecordRequestBody *bool
+
+	// IsRecordResponseBody overrides ActivityLogConfig.IsRecordResponseBody when not nil.
+	IsRecordResponseBody *bool
+
+	// IsRecordHeader overrides ActivityLogConfig.IsRecordHeader when not nil.
+	IsRecordHeader *bool
+
+	// IsPublishWhenNoActivities overrides ActivityLogConfig.IsPublishWhenNoActivities when not nil.
+	IsPublishWhenNoActivities *bool
+
+	// TopicName overrides ActivityLogConfig.TopicName when not empty.
+	TopicName string
+
+	// EventType is used to store the default event type of the event log.
+	EventType string
+
+	// Resource is used to store the default resource of the event log.
+	Resource string
+
+	// Type is used to store the default type of the event log.
+	Type string
+}
+
+// Bool returns a pointer to the value, to fill the overrides of RoutePolicy.
+func Bool(value bool) *bool {
+	return &value
+}
+
+// routePolicies holds the policies ordered by precedence:
+// exact patterns first, then the patterns with the most literal characters,
+// then the policies with a method. Ties keep the configured order.
+type routePolicies []RoutePolicy
+
+func newRoutePolicies(policies []RoutePolicy) routePolicies {
+	sorted := make(routePolicies, len(policies))
+	copy(sorted, policies)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+
+		if exactA, exactB := !strings.Contains(a.Pattern, "*"), !strings.Contains(b.Pattern, "*"); exactA != exactB {
+			return exactA
+		}
+
+		if literalA, literalB := len(strings.ReplaceAll(a.Pattern, "*", "")), len(strings.ReplaceAll(b.Pattern, "*", "")); literalA != literalB {
+			return literalA > literalB
+		}
+
+		return anyMethod(a.Method) != anyMethod(b.Method) && !anyMethod(a.Method)
+	})
+
+	return sorted
+}
+
+// To find the policy of the route, nil when no policy matches.
+func (p routePolicies) match(method string, pattern string) *RoutePolicy {
+	for i := range p {
+		policy := &p[i]
+		if !anyMethod(policy.Method) && !strings.EqualFold(policy.Method, method) {
+			continue
+		}
+		if wildcardMatch(policy.Pattern, pattern) {
+			return policy
+		}
+	}
+	return nil
+}
+
+func anyMethod(method string) bool {
+	return method == "" || method == "*"
+}
+
+// To match the value against a pattern where "*" matches any sequence of characters.
+func wildcardMatch(pattern string, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+
+// To apply the overrides of the policy to the config.
+func (p *RoutePolicy) apply(cfg ActivityLogConfig) ActivityLogConfig {
+	if p == nil {
+		return cfg
+	}
+
+	if p.IsRecordRequestBody != nil {
+		cfg.IsRecordRequestBody = *p.IsRecordRequestBody
+	}
+	if p.IsRecordResponseBody != nil {
+		cfg.IsRecordResponseBody = *p.IsRecordResponseBody
+	}
+	if p.IsRecordHeader != nil {
+		cfg.IsRecordHeader = *p.IsRecordHeader
+	}
+	if p.IsPublishWhenNoActivities != nil {
+		cfg.IsPublishWhenNoActivities = *p.IsPublishWhenNoActivities
+	}
+	if p.TopicName != "" {
+		cfg.TopicName = p.TopicName
+	}
+	return cfg
+}
@@ -0,0 +1,115 @@
+package audittrail
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/go-chi/chi"
+)
+
+func TestRoutePoliciesMatch(t *testing.T) {
+	policies := newRoutePolicies([]RoutePolicy{
+		{Pattern: "/api/*", TopicName: "any"},
+		{Pattern: "/api/v1/loans/*", TopicName: "loans"},
+		{Method: "POST", Pattern: "/api/v1/loans/*", TopicName: "post-loans"},
+		{Pattern: "/api/v1/loans/{id}/approve", TopicName: "approve"},
+		{Pattern: "/health*", Skip: true},
+	})
+
+	tests := []struct {
+		method   string
+		pattern  string
+		expected string
+	}{
+		{"GET", "/api/v1/users", "any"},
+		{"GET", "/api/v1/loans/{id}", "loans"},
+		{"post", "/api/v1/loans/{id}", "post-loans"},
+		{"POST", "/api/v1/loans/{id}/approve", "approve"},
+		{"GET", "/metrics", ""},
+	}
+
+	for _, test := range tests {
+		topic := ""
+		if policy := policies.match(test.method, test.pattern); policy != nil {
+			topic = policy.TopicName
+		}
+		if topic != test.expected {
+			t.Errorf("Expected %s %s to match %q, but got %q", test.method, test.pattern, test.expected, topic)
+		}
+	}
+
+	if policy := policies.match("GET", "/healthz"); policy == nil || !policy.Skip {
+		t.Errorf("Expected /healthz to be skipped, but got %+v", policy)
+	}
+}
+
+func TestNewActivityLogMiddlewareRoutePolicies(t *testing.T) {
+	publisher := gochannel.NewGoChannel(gochannel.Config{}, nil)
+	cfg := ActivityLogConfig{
+		ServiceName:               "testService",
+		IsRecordResponseBody:      true,
+		IsPublishWhenNoActivities: true,
+		TopicName:                 "testTopic",
+		RoutePolicies: []RoutePolicy{
+			{Pattern: "/health", Skip: true},
+			{
+				Method:               "GET",
+				Pattern:              "/users/{id}",
+				IsRecordResponseBody: Bool(false),
+				TopicName:            "userTopic",
+				EventType:            "View User",
+				Resource:             "user",
+			},
+		},
+	}
+
+	defaultTopic, _ := publisher.Subscribe(context.Background(), cfg.TopicName)
+	userTopic, _ := publisher.Subscribe(context.Background(), "userTopic")
+
+	r := chi.NewRouter()
+	r.Use(NewActivityLogMiddleware(publisher, cfg)...)
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		if FromContext(r.Context()) != nil {
+			t.Errorf("Expected skipped route not to have a transaction")
+		}
+
+		// Handlers of skipped routes still record their activities unconditionally
+		FromContext(r.Context()).SetActor("actor").SetResource("health")
+		activity := FromContext(r.Context()).StartAction("check", "check health")
+		activity.SetTargetUserID("user").SetDataBefore(1).SetDataAfter(2).Succeed()
+		activity.StartChild("ping", "ping database").End()
+		activity.End()
+
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"Budi"}`))
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+
+	msgs := <-userTopic
+	msgs.Ack()
+
+	var result Transaction
+	json.Unmarshal(msgs.Payload, &result)
+
+	if result.EventType != "View User" || result.Resource != "user" {
+		t.Errorf("Expected EventType and Resource to be set by the policy, but got %s and %s", result.EventType, result.Resource)
+	}
+
+	if result.ResponseBody != nil {
+		t.Errorf("Expected ResponseBody not to be recorded, but got %v", result.ResponseBody)
+	}
+
+	select {
+	case msg := <-defaultTopic:
+		t.Errorf("Expected skipped route not to be published, but got %s", msg.Payload)
+	default:
+	}
+}
@@ -0,0 +1,164 @@
+package audittrail
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ActorAuthMethodJWT is the method of the actors identified by a bearer JWT.
+	ActorAuthMethodJWT = "jwt"
+
+	// ActorAuthMethodMTLS is the method of the actors identified by a verified client certificate.
+	ActorAuthMethodMTLS = "mtls"
+
+	// ActorAuthMethodAPIKey is the method of the actors identified by an API key.
+	ActorAuthMethodAPIKey = "apiKey"
+)
+
+// CertificateIdentity is used to store the identity of a client certificate.
+type CertificateIdentity struct {
+
+	// Subject is used to store the distinguished name of the certificate subject.
+	Subject string `json:"subject"`
+
+	// Issuer is used to store the distinguished name of the certificate issuer.
+	Issuer string `json:"issuer"`
+
+	// SerialNumber is used to store the serial number of the certificate.
+	SerialNumber string `json:"serialNumber"`
+
+	// URIs is used to store the URI subject alternative names of the certificate.
+	URIs []string `json:"uris,omitempty"`
+
+	// SPIFFEID is used to store the SPIFFE ID of the certificate, if any.
+	SPIFFEID string `json:"spiffeId,omitempty"`
+
+	// FingerprintSHA256 is used to store the hex encoded SHA-256 of the certificate.
+	FingerprintSHA256 string `json:"fingerprintSha256"`
+}
+
+// NewClientCertActorResolver creates an ActorResolver that identifies the actor
+// from the client certificate verified by the TLS handshake.
+// The actor ID is the SPIFFE ID, else the first URI SAN, else the subject common name.
+// Certificates that were not verified against the configured client CAs are ignored.
+func NewClientCertActorResolver(actorType string) ActorResolver {
+	return ActorResolverFunc(func(r *http.Request) (*ResolvedActor, error) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return nil, nil
+		}
+
+		cert := r.TLS.VerifiedChains[0][0]
+		identity := newCertificateIdentity(cert)
+		actor := &ResolvedActor{
+			ID:          identity.SPIFFEID,
+			Type:        actorType,
+			Method:      ActorAuthMethodMTLS,
+			Certificate: identity,
+		}
+
+		if actor.ID == "" && len(identity.URIs) != 0 {
+			actor.ID = identity.URIs[0]
+		}
+		if actor.ID == "" {
+			actor.ID = cert.Subject.CommonName
+		}
+		if len(cert.EmailAddresses) != 0 {
+			actor.Email = cert.EmailAddresses[0]
+		}
+
+		return actor, nil
+	})
+}
+
+func newCertificateIdentity(cert *x509.Certificate) *CertificateIdentity {
+	fingerprint := sha256.Sum256(cert.Raw)
+	identity := &CertificateIdentity{
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		SerialNumber:      cert.SerialNumber.String(),
+		FingerprintSHA256: hex.EncodeToString(fingerprint[:]),
+	}
+
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+		if uri.Scheme == "spiffe" && identity.SPIFFEID == "" {
+			identity.SPIFFEID = uri.String()
+		}
+	}
+
+	return identity
+}
+
+// APIKeyStore is used to look up the actor of an API key.
+// Keys are looked up by the hex encoded SHA-256 of the key, so the store never holds plain keys.
+type APIKeyStore interface {
+
+	// LookupAPIKey returns the actor of the key hash, or nil when the key is unknown.
+	LookupAPIKey(ctx context.Context, keyHash string) (*ResolvedActor, error)
+}
+
+// StaticAPIKeyStore is an APIKeyStore backed by a map of key hashes.
+type StaticAPIKeyStore map[string]ResolvedActor
+
+func (s StaticAPIKeyStore) LookupAPIKey(_ context.Context, keyHash string) (*ResolvedActor, error) {
+	actor, ok := s[keyHash]
+	if !ok {
+		return nil, nil
+	}
+	return &actor, nil
+}
+
+// HashAPIKey returns the hash an API key is stored under in an APIKeyStore.
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// ErrUnknownAPIKey is returned when the API key of the request is not in the store.
+var ErrUnknownAPIKey = errors.New("unknown api key")
+
+// NewAPIKeyActorResolver creates an ActorResolver that identifies the actor
+// from the API key of the header, e.g. "X-Api-Key". Defaults to "X-Api-Key" when empty.
+// The header is masked in the event log like the DefaultSensitiveHeaders,
+// also when the resolver is chained with ChainActorResolvers.
+func NewAPIKeyActorResolver(header string, store APIKeyStore) ActorResolver {
+	if header == "" {
+		header = "X-Api-Key"
+	}
+	return &apiKeyActorResolver{header: header, store: store}
+}
+
+type apiKeyActorResolver struct {
+	header string
+	store  APIKeyStore
+}
+
+func (a *apiKeyActorResolver) ResolveActor(r *http.Request) (*ResolvedActor, error) {
+	key := strings.TrimSpace(r.Header.Get(a.header))
+	if key == "" {
+		return nil, nil
+	}
+
+	stored, err := a.store.LookupAPIKey(r.Context(), HashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, ErrUnknownAPIKey
+	}
+
+	// The store may share the actor between requests, so it is copied before it is changed
+	actor := *stored
+	actor.Method = ActorAuthMethodAPIKey
+	return &actor, nil
+}
+
+func (a *apiKeyActorResolver) sensitiveHeaders() []string {
+	return []string{a.header}
+}
@@ -0,0 +1,185 @@
+package audittrail
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, commonName string, uris ...string) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Tunaiku"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	for _, uri := range uris {
+		parsed, _ := url.Parse(uri)
+		template.URIs = append(template.URIs, parsed)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func TestClientCertActorResolver(t *testing.T) {
+	resolver := NewClientCertActorResolver("service")
+
+	t.Run("resolves the spiffe id of a verified certificate", func(t *testing.T) {
+		cert := newTestCertificate(t, "loan-service", "spiffe://tunaiku.com/ns/loan/sa/loan-service")
+		req := httptest.NewRequest("GET", "/", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+		actor, err := resolver.ResolveActor(req)
+		if err != nil {
+			t.Fatalf("Error resolving actor: %v", err)
+		}
+
+		if actor.ID != "spiffe://tunaiku.com/ns/loan/sa/loan-service" {
+			t.Errorf("Expected actor ID to be the spiffe id, but got %s", actor.ID)
+		}
+		if actor.Type != "service" || actor.Method != ActorAuthMethodMTLS {
+			t.Errorf("Expected a service actor identified by mtls, but got %+v", actor)
+		}
+		if actor.Certificate.Subject != "CN=loan-service,O=Tunaiku" {
+			t.Errorf("Expected certificate subject to be %s, but got %s", "CN=loan-service,O=Tunaiku", actor.Certificate.Subject)
+		}
+		if len(actor.Certificate.FingerprintSHA256) != 64 {
+			t.Errorf("Expected a SHA-256 fingerprint, but got %s", actor.Certificate.FingerprintSHA256)
+		}
+	})
+
+	t.Run("falls back to the common name", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{newTestCertificate(t, "loan-service")}}}
+
+		actor, _ := resolver.ResolveActor(req)
+		if actor == nil || actor.ID != "loan-service" {
+			t.Errorf("Expected actor ID to be %s, but got %+v", "loan-service", actor)
+		}
+	})
+
+	t.Run("ignores unverified certificates", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{newTestCertificate(t, "forged")}}
+
+		actor, err := resolver.ResolveActor(req)
+		if actor != nil || err != nil {
+			t.Errorf("Expected no actor and no error, but got %+v and %v", actor, err)
+		}
+	})
+}
+
+func TestAPIKeyActorResolver(t *testing.T) {
+	store := StaticAPIKeyStore{
+		HashAPIKey("secret-key"): {ID: "collection-service", Type: "service"},
+	}
+	resolver := NewAPIKeyActorResolver("", store)
+
+	t.Run("resolves a known key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Api-Key", "secret-key")
+
+		actor, err := resolver.ResolveActor(req)
+		if err != nil {
+			t.Fatalf("Error resolving actor: %v", err)
+		}
+		if actor.ID != "collection-service" || actor.Method != ActorAuthMethodAPIKey {
+			t.Errorf("Expected collection-service identified by api key, but got %+v", actor)
+		}
+	})
+
+	t.Run("does not change the store", func(t *testing.T) {
+		if store[HashAPIKey("secret-key")].Method != "" {
+			t.Errorf("Expected the stored actor to be left untouched")
+		}
+	})
+
+	t.Run("rejects an unknown key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Api-Key", "other-key")
+
+		if _, err := resolver.ResolveActor(req); !errors.Is(err, ErrUnknownAPIKey) {
+			t.Errorf("Expected error to be %v, but got %v", ErrUnknownAPIKey, err)
+		}
+	})
+
+	t.Run("does not change a shared actor", func(t *testing.T) {
+		shared := &ResolvedActor{ID: "collection-service"}
+		resolver := NewAPIKeyActorResolver("", sharedAPIKeyStore{shared})
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Api-Key", "secret-key")
+		actor, _ := resolver.ResolveActor(req)
+
+		if actor == shared || shared.Method != "" {
+			t.Errorf("Expected the shared actor to be copied, but got %+v", shared)
+		}
+	})
+
+	t.Run("masks a custom header", func(t *testing.T) {
+		cfg := ActivityLogConfig{ActorResolver: ChainActorResolvers(NewAPIKeyActorResolver("X-Collection-Key", store))}
+		header := newHeaderRedactor(cfg).redact(http.Header{"X-Collection-Key": {"secret-key"}})
+
+		if value := header["X-Collection-Key"].([]string)[0]; value != MaskedValue {
+			t.Errorf("Expected X-Collection-Key to be %s, but got %s", MaskedValue, value)
+		}
+	})
+}
+
+// sharedAPIKeyStore returns the same actor for every key, like a cache would.
+type sharedAPIKeyStore struct {
+	actor *ResolvedActor
+}
+
+func (s sharedAPIKeyStore) LookupAPIKey(_ context.Context, _ string) (*ResolvedActor, error) {
+	return s.actor, nil
+}
+
+func TestChainActorResolvers(t *testing.T) {
+	failing := ActorResolverFunc(func(r *http.Request) (*ResolvedActor, error) {
+		return nil, errors.New("invalid bearer token")
+	})
+	none := ActorResolverFunc(func(r *http.Request) (*ResolvedActor, error) {
+		return nil, nil
+	})
+	apiKey := NewAPIKeyActorResolver("", StaticAPIKeyStore{
+		HashAPIKey("secret-key"): {ID: "collection-service"},
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Api-Key", "secret-key")
+
+	actor, err := ChainActorResolvers(failing, none, apiKey).ResolveActor(req)
+	if err != nil || actor == nil || actor.ID != "collection-service" {
+		t.Errorf("Expected the api key actor to win, but got %+v and %v", actor, err)
+	}
+
+	actor, err = ChainActorResolvers(failing, none).ResolveActor(req)
+	if actor != nil || err == nil {
+		t.Errorf("Expected the first error when nothing resolves, but got %+v and %v", actor, err)
+	}
+
+	log := &Transaction{}
+	resolveActor(ChainActorResolvers(none, apiKey), req.WithContext(context.Background()), log)
+	if log.ActorAuthMethod != ActorAuthMethodAPIKey {
+		t.Errorf("Expected ActorAuthMethod to be %s, but got %s", ActorAuthMethodAPIKey, log.ActorAuthMethod)
+	}
+}
@@ -0,0 +1,602 @@
+package audittrail
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+)
+
+// SQLStatement is used to store a write statement of an activity, see WrapSQLDriver.
+type SQLStatement struct {
+
+	// Query is used to store the normalized query.
+	// Comments are removed, whitespace is collapsed and literal values are replaced with "?".
+	Query string `json:"query"`
+
+	// Operation is used to store the kind of the statement, e.g. "UPDATE".
+	Operation string `json:"operation"`
+
+	// Tables is used to store the tables written by the statement.
+	Tables []string `json:"tables"`
+
+	// RowsAffected is used to store the number of affected rows, -1 when the driver does not report it.
+	RowsAffected int64 `json:"rowsAffected"`
+
+	// DurationMs is used to store the duration of the statement, in milliseconds.
+	DurationMs float64 `json:"durationMs"`
+
+	// Error is used to store the error of the statement.
+	Error string `json:"error,omitempty"`
+
+	// Args is used to store the bound parameter values, only when IsRecordArgs is set.
+	Args []interface{} `json:"args,omitempty"`
+}
+
+// SQLDialect is the quoting rules the queries are normalized with.
+type SQLDialect string
+
+const (
+	// SQLDialectANSI quotes identifiers with double quotes, e.g. PostgreSQL and SQLite.
+	// PostgreSQL dollar-quoted strings, e.g. $$text$$ or $tag$text$tag$, are string literals too.
+	SQLDialectANSI SQLDialect = ""
+
+	// SQLDialectMySQL quotes strings with double quotes and escapes quotes with backslashes,
+	// as MySQL and MariaDB do without the ANSI_QUOTES SQL mode.
+	SQLDialectMySQL SQLDialect = "mysql"
+)
+
+// SQLConfig is used to configure the database/sql instrumentation.
+type SQLConfig struct {
+
+	// IsRecordArgs is used to determine whether the bound parameter values are recorded.
+	// They are never recorded by default, as they usually hold personal data.
+	IsRecordArgs bool
+
+	// Dialect is used to store the quoting rules of the database, so the string literals
+	// of the queries are replaced. Defaults to SQLDialectANSI.
+	Dialect SQLDialect
+}
+
+// WrapSQLDriver wraps a database/sql driver to record the INSERT, UPDATE and DELETE statements
+// executed with a context that carries an event log, see FromContext, as activities nested in the
+// innermost segment of the context, see StartActionCtx.
+// Other statements and statements without an event log are passed through untouched.
+//
+// The statements of a database transaction are recorded when it commits, and recorded as failed when it rolls back.
+//
+// Example:
+//
+//	sql.Register("sqlite3-audit", activitylog.WrapSQLDriver(&sqlite3.SQLiteDriver{}, activitylog.SQLConfig{}))
+//	db, err := sql.Open("sqlite3-audit", dsn)
+func WrapSQLDriver(d driver.Driver, cfg SQLConfig) driver.Driver {
+	if dc, ok := d.(driver.DriverContext); ok {
+		return &sqlDriverContext{sqlDriver{Driver: d, cfg: cfg}, dc}
+	}
+	return &sqlDriver{Driver: d, cfg: cfg}
+}
+
+// NewSQLConnector wraps a database/sql connector like WrapSQLDriver, to be opened with sql.OpenDB.
+func NewSQLConnector(c driver.Connector, cfg SQLConfig) driver.Connector {
+	return &sqlConnector{Connector: c, cfg: cfg}
+}
+
+type sqlDriver struct {
+	driver.Driver
+	cfg SQLConfig
+}
+
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{Conn: conn, cfg: d.cfg}, nil
+}
+
+type sqlDriverContext struct {
+	sqlDriver
+	dc driver.DriverContext
+}
+
+func (d *sqlDriverContext) OpenConnector(name string) (driver.Connector, error) {
+	connector, err := d.dc.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConnector{Connector: connector, cfg: d.cfg, driver: d}, nil
+}
+
+type sqlConnector struct {
+	driver.Connector
+	cfg    SQLConfig
+	driver driver.Driver
+}
+
+func (c *sqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{Conn: conn, cfg: c.cfg}, nil
+}
+
+func (c *sqlConnector) Driver() driver.Driver {
+	if c.driver != nil {
+		return c.driver
+	}
+	return &sqlDriver{Driver: c.Connector.Driver(), cfg: c.cfg}
+}
+
+type sqlConn struct {
+	driver.Conn
+	cfg SQLConfig
+	tx  *sqlTx
+}
+
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{Stmt: stmt, query: query, conn: c}, nil
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.tx = &sqlTx{Tx: tx, conn: c}
+	return c.tx, nil
+}
+
+// To record a write statement run on the connection.
+// Inside a database transaction, the activity is held until the transaction ends.
+func (c *sqlConn) record(ctx context.Context, query string, args []driver.NamedValue, start time.Time, result driver.Result, err error) {
+	segment := startSQLSegment(ctx, c.cfg, query, args, start, result, err)
+	if segment == nil {
+		return
+	}
+
+	if c.tx != nil {
+		c.tx.segments = append(c.tx.segments, segment)
+		return
+	}
+	segment.End()
+}
+
+// errSQLRollback is recorded on the statements of a database transaction that rolled back.
+var errSQLRollback = errors.New("sql: transaction rolled back")
+
+// sqlTx holds the activities of the statements of a database transaction until it ends.
+// database/sql uses a connection from one goroutine at a time, so it needs no lock.
+type sqlTx struct {
+	driver.Tx
+	conn     *sqlConn
+	segments []*Segment
+}
+
+func (t *sqlTx) Commit() error {
+	err := t.Tx.Commit()
+	t.end(err)
+	return err
+}
+
+func (t *sqlTx) Rollback() error {
+	err := t.Tx.Rollback()
+	t.end(errSQLRollback)
+	return err
+}
+
+// To end the activities of the statements, failed with err when the transaction did not commit.
+// A statement that failed on its own keeps its error.
+func (t *sqlTx) end(err error) {
+	if t.conn.tx == t {
+		t.conn.tx = nil
+	}
+
+	for _, segment := range t.segments {
+		if err != nil && segment.Activity.Error == nil {
+			segment.fail(err, 1)
+		}
+		segment.End()
+	}
+	t.segments = nil
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		// database/sql prepares the statement instead, which is recorded by the statement
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		return result, err
+	}
+
+	c.record(ctx, query, args, start, result, err)
+	return result, err
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if errors.Is(err, driver.ErrSkip) {
+		return rows, err
+	}
+
+	// Writes with a RETURNING clause are run as queries
+	c.record(ctx, query, args, start, nil, err)
+	return rows, err
+}
+
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *sqlConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type sqlStmt struct {
+	driver.Stmt
+	query string
+	conn  *sqlConn
+}
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+
+	var result driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		result, err = s.Stmt.Exec(namedValues(args))
+	}
+
+	s.conn.record(ctx, s.query, args, start, result, err)
+	return result, err
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(namedValues(args))
+	}
+
+	s.conn.record(ctx, s.query, args, start, nil, err)
+	return rows, err
+}
+
+func (s *sqlStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+// To start the activity of a write statement in the event log of the context,
+// nested in the innermost segment of the context. The caller ends it.
+// It returns nil when the statement is not recorded.
+func startSQLSegment(ctx context.Context, cfg SQLConfig, query string, args []driver.NamedValue, start time.Time, result driver.Result, err error) *Segment {
+	root := FromContext(ctx)
+	if root == nil {
+		return nil
+	}
+
+	normalized, operation, tables := parseSQL(query, cfg.Dialect)
+	if operation == "" {
+		return nil
+	}
+
+	statement := &SQLStatement{
+		Query:        normalized,
+		Operation:    operation,
+		Tables:       tables,
+		RowsAffected: -1,
+		DurationMs:   durationMs(time.Since(start)),
+	}
+	if err != nil {
+		statement.Error = err.Error()
+	}
+	if result != nil {
+		if rows, err := result.RowsAffected(); err == nil {
+			statement.RowsAffected = rows
+		}
+	}
+	if cfg.IsRecordArgs {
+		for _, arg := range args {
+			statement.Args = append(statement.Args, arg.Value)
+		}
+	}
+
+	segment := startSegmentCtx(ctx, strings.ToLower(operation), strings.TrimSpace(operation+" "+strings.Join(tables, ", ")))
+	segment.Activity.StartTime = start
+	segment.Activity.SQL = statement
+	if err != nil {
+		segment.Fail(err)
+	} else {
+		segment.Succeed()
+	}
+	return segment
+}
+
+// sqlWriteOperations are the statements recorded as activities.
+var sqlWriteOperations = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true}
+
+// To normalize the query, and find the write operation and the tables it writes.
+// The operation is empty when the query is not a write.
+func parseSQL(query string, dialect SQLDialect) (string, string, []string) {
+	tokens := tokenizeSQL(query, dialect)
+	normalized := joinSQLTokens(tokens)
+
+	// The write of a statement with common table expressions is its first top level write
+	depth := 0
+	for i, token := range tokens {
+		switch token.text {
+		case "(":
+			depth++
+			continue
+		case ")":
+			depth--
+			continue
+		}
+		if depth != 0 || token.kind != sqlKeyword {
+			continue
+		}
+
+		keyword := strings.ToUpper(token.text)
+		if i == 0 && keyword != "WITH" && !sqlWriteOperations[keyword] {
+			return normalized, "", nil
+		}
+		if sqlWriteOperations[keyword] {
+			return normalized, keyword, sqlTargetTables(keyword, tokens[i+1:])
+		}
+	}
+
+	return normalized, "", nil
+}
+
+// To find the table of "INSERT [OR ...] INTO t", "UPDATE [OR ...] [ONLY] t" and "DELETE FROM t".
+func sqlTargetTables(operation string, tokens []sqlToken) []string {
+	skip := map[string]bool{"OR": true, "REPLACE": true, "IGNORE": true, "ABORT": true, "ROLLBACK": true, "FAIL": true, "ONLY": true, "LOW_PRIORITY": true, "QUICK": true, "INTO": true, "FROM": true}
+
+	for _, token := range tokens {
+		if token.kind != sqlKeyword {
+			break
+		}
+		if skip[strings.ToUpper(token.text)] {
+			continue
+		}
+		return []string{token.name()}
+	}
+	return nil
+}
+
+const (
+	sqlKeyword = iota
+	sqlLiteral
+	sqlPlaceholder
+	sqlSymbol
+)
+
+type sqlToken struct {
+	kind int
+	text string
+}
+
+// To get the identifier without its quotes, e.g. `"public"."loans"` is "public.loans".
+func (t sqlToken) name() string {
+	return strings.NewReplacer(`"`, "", "`", "", "[", "", "]", "").Replace(t.text)
+}
+
+// To split the query into tokens, without comments and with the literals replaced.
+func tokenizeSQL(query string, dialect SQLDialect) []sqlToken {
+	var tokens []sqlToken
+	isMySQL := dialect == SQLDialectMySQL
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i - 4
+			}
+			i += end + 4
+
+		case c == '\'' || c == '"' && isMySQL:
+			tokens = append(tokens, sqlToken{sqlLiteral, "?"})
+			i = skipSQLString(query, i, isMySQL)
+
+		case c == '$' && !isMySQL && sqlDollarQuoteTag(query[i:]) != "":
+			// PostgreSQL dollar-quoted strings, e.g. $$it's$$ or $body$...$body$
+			tag := sqlDollarQuoteTag(query[i:])
+			tokens = append(tokens, sqlToken{sqlLiteral, "?"})
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				i = len(query)
+				continue
+			}
+			i += len(tag) + end + len(tag)
+
+		case c == '"' || c == '`' || c == '[':
+			closing := map[byte]byte{'"': '"', '`': '`', '[': ']'}[c]
+			end := strings.IndexByte(query[i+1:], closing)
+			if end < 0 {
+				end = len(query) - i - 2
+			}
+			text := query[i : i+end+2]
+			i += end + 2
+			// Dotted names, e.g. "public"."loans", are one identifier
+			if n := len(tokens); n != 0 && strings.HasSuffix(tokens[n-1].text, ".") {
+				tokens[n-1].text += text
+				continue
+			}
+			tokens = append(tokens, sqlToken{sqlKeyword, text})
+
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(query) && (isSQLWordByte(query[j]) || query[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{sqlLiteral, "?"})
+			i = j
+
+		case c == '?' || c == '$' || c == ':' || c == '@':
+			j := i + 1
+			for j < len(query) && isSQLWordByte(query[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{sqlPlaceholder, query[i:j]})
+			i = j
+
+		case isSQLWordByte(c):
+			j := i
+			for j < len(query) && (isSQLWordByte(query[j]) || query[j] == '.') {
+				j++
+			}
+			text := query[i:j]
+			i = j
+			if n := len(tokens); n != 0 && strings.HasSuffix(tokens[n-1].text, ".") && tokens[n-1].kind == sqlKeyword {
+				tokens[n-1].text += text
+				continue
+			}
+			tokens = append(tokens, sqlToken{sqlKeyword, text})
+
+		default:
+			if c == '.' && len(tokens) != 0 && tokens[len(tokens)-1].kind == sqlKeyword {
+				tokens[len(tokens)-1].text += "."
+				i++
+				continue
+			}
+			tokens = append(tokens, sqlToken{sqlSymbol, string(c)})
+			i++
+		}
+	}
+
+	return tokens
+}
+
+// To find the end of the string literal starting at i, with a doubled quote as the escaped quote.
+// MySQL also escapes with a backslash.
+func skipSQLString(query string, i int, isBackslashEscape bool) int {
+	quote := query[i]
+	j := i + 1
+	for j < len(query) {
+		switch {
+		case query[j] == '\\' && isBackslashEscape:
+			j += 2
+			continue
+		case query[j] == quote:
+			if j+1 < len(query) && query[j+1] == quote {
+				j += 2
+				continue
+			}
+			return j + 1
+		}
+		j++
+	}
+	return len(query)
+}
+
+// To get the opening tag of a dollar-quoted string at the start of the query, e.g. "$$" or "$body$".
+// It is empty for placeholders like $1, as tags can not start with a digit.
+func sqlDollarQuoteTag(query string) string {
+	for j := 1; j < len(query); j++ {
+		switch c := query[j]; {
+		case c == '$':
+			return query[:j+1]
+		case j == 1 && c >= '0' && c <= '9', !isSQLWordByte(c):
+			return ""
+		}
+	}
+	return ""
+}
+
+func isSQLWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// To join the tokens into the normalized query.
+func joinSQLTokens(tokens []sqlToken) string {
+	var b strings.Builder
+	for i, token := range tokens {
+		if i != 0 && token.text != "," && token.text != ")" && tokens[i-1].text != "(" {
+			b.WriteByte(' ')
+		}
+		b.WriteString(token.text)
+	}
+	return b.String()
+}
@@ -0,0 +1,175 @@
+package audittrail
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	sql.Register("sqlite3-audit", WrapSQLDriver(&sqlite3.SQLiteDriver{}, SQLConfig{}))
+	sql.Register("sqlite3-audit-args", WrapSQLDriver(&sqlite3.SQLiteDriver{}, SQLConfig{IsRecordArgs: true}))
+}
+
+func newTestDB(t *testing.T, driverName string) *sql.DB {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`CREATE TABLE loans (id INTEGER PRIMARY KEY, nik TEXT, status TEXT)`); err != nil {
+		if strings.Contains(err.Error(), "CGO_ENABLED") {
+			t.Skip("sqlite3 requires cgo")
+		}
+		t.Fatalf("Error creating table: %v", err)
+	}
+	return db
+}
+
+func TestWrapSQLDriverRecordsWrites(t *testing.T) {
+	db := newTestDB(t, "sqlite3-audit")
+
+	log := &Transaction{}
+	ctx := NewContext(context.Background(), log)
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO loans (nik, status) VALUES (?, 'pending'), (?, 'pending')", "3171234567890001", "3171234567890002"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+
+	stmt, _ := db.PrepareContext(ctx, `UPDATE "loans" SET status = ? WHERE id = 1`)
+	if _, err := stmt.ExecContext(ctx, "approved"); err != nil {
+		t.Fatalf("Error updating: %v", err)
+	}
+	stmt.Close()
+
+	var status string
+	db.QueryRowContext(ctx, "SELECT status FROM loans WHERE id = 1").Scan(&status)
+	db.ExecContext(ctx, "DELETE FROM missing_table")
+
+	if len(log.Activities) != 3 {
+		t.Fatalf("Expected 3 activities, but got %d", len(log.Activities))
+	}
+
+	insert := log.Activities[0]
+	if insert.Action != "insert" || insert.Status != "success" {
+		t.Errorf("Expected a successful insert activity, but got %s %s", insert.Action, insert.Status)
+	}
+	expected := &SQLStatement{
+		Query:        "INSERT INTO loans (nik, status) VALUES (?, ?), (?, ?)",
+		Operation:    "INSERT",
+		Tables:       []string{"loans"},
+		RowsAffected: 2,
+		DurationMs:   insert.SQL.DurationMs,
+	}
+	if !reflect.DeepEqual(insert.SQL, expected) {
+		t.Errorf("Expected statement to be %+v, but got %+v", expected, insert.SQL)
+	}
+
+	update := log.Activities[1].SQL
+	if update.Operation != "UPDATE" || update.Tables[0] != "loans" || update.RowsAffected != 1 || update.Args != nil {
+		t.Errorf("Expected the update to be recorded without args, but got %+v", update)
+	}
+
+	failed := log.Activities[2]
+	if failed.Status != "failed" || !strings.Contains(failed.SQL.Error, "no such table") {
+		t.Errorf("Expected the failed delete to be recorded, but got %+v", failed.SQL)
+	}
+}
+
+func TestWrapSQLDriverRecordsArgsWhenAllowed(t *testing.T) {
+	db := newTestDB(t, "sqlite3-audit-args")
+
+	log := &Transaction{}
+	if _, err := db.ExecContext(NewContext(context.Background(), log), "INSERT INTO loans (nik) VALUES (?)", "3171234567890001"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+
+	if len(log.Activities) != 1 || !reflect.DeepEqual(log.Activities[0].SQL.Args, []interface{}{"3171234567890001"}) {
+		t.Errorf("Expected the args to be recorded, but got %+v", log.Activities)
+	}
+}
+
+func TestWrapSQLDriverIgnoresContextWithoutTransaction(t *testing.T) {
+	db := newTestDB(t, "sqlite3-audit")
+
+	if _, err := db.ExecContext(context.Background(), "INSERT INTO loans (nik) VALUES ('3171234567890001')"); err != nil {
+		t.Errorf("Expected the statement to pass through, but got %v", err)
+	}
+}
+
+func TestParseSQL(t *testing.T) {
+	tests := []struct {
+		query      string
+		dialect    SQLDialect
+		normalized string
+		operation  string
+		tables     []string
+	}{
+		{"update  public.loans\n SET amount = 1000.50 -- raise\n WHERE nik = 'x''y'", SQLDialectANSI, "update public.loans SET amount = ? WHERE nik = ?", "UPDATE", []string{"public.loans"}},
+		{`INSERT OR REPLACE INTO "public"."loans" (id) VALUES ($1)`, SQLDialectANSI, `INSERT OR REPLACE INTO "public"."loans" (id) VALUES ($1)`, "INSERT", []string{"public.loans"}},
+		{"WITH old AS (SELECT id FROM loans) DELETE FROM /* all */ loans WHERE id IN (SELECT id FROM old)", SQLDialectANSI, "WITH old AS (SELECT id FROM loans) DELETE FROM loans WHERE id IN (SELECT id FROM old)", "DELETE", []string{"loans"}},
+		{"UPDATE loans SET note = $$hunter2 isn't$$, memo = $tag$a $$ b$tag$ WHERE id = $1", SQLDialectANSI, "UPDATE loans SET note = ?, memo = ? WHERE id = $1", "UPDATE", []string{"loans"}},
+		{"INSERT INTO loans (note) VALUES ($$unterminated", SQLDialectANSI, "INSERT INTO loans (note) VALUES (?", "INSERT", []string{"loans"}},
+		{"SELECT * FROM loans WHERE id = 1", SQLDialectANSI, "SELECT * FROM loans WHERE id = ?", "", nil},
+		{"UPDATE `loans` SET nik = \"3171234567890001\", note = 'it\\'s \"due\"' WHERE id = 1", SQLDialectMySQL, "UPDATE `loans` SET nik = ?, note = ? WHERE id = ?", "UPDATE", []string{"loans"}},
+	}
+
+	for _, test := range tests {
+		normalized, operation, tables := parseSQL(test.query, test.dialect)
+		if normalized != test.normalized {
+			t.Errorf("Expected normalized query to be %s, but got %s", test.normalized, normalized)
+		}
+		if operation != test.operation || !reflect.DeepEqual(tables, test.tables) {
+			t.Errorf("Expected %s %v, but got %s %v", test.operation, test.tables, operation, tables)
+		}
+	}
+}
+
+func TestWrapSQLDriverNestsInSegment(t *testing.T) {
+	db := newTestDB(t, "sqlite3-audit")
+
+	log := &Transaction{}
+	ctx, segment := StartActionCtx(NewContext(context.Background(), log), "approve", "approve loan")
+	if _, err := db.ExecContext(ctx, "INSERT INTO loans (nik) VALUES ('3171234567890001')"); err != nil {
+		t.Fatalf("Error inserting: %v", err)
+	}
+	segment.End()
+
+	if len(log.Activities) != 2 || log.Activities[1].ParentID != segment.Activity.ID {
+		t.Errorf("Expected the insert to be nested in %s, but got %+v", segment.Activity.ID, log.Activities)
+	}
+}
+
+func TestWrapSQLDriverRecordsTransactionWhenItEnds(t *testing.T) {
+	db := newTestDB(t, "sqlite3-audit")
+
+	log := &Transaction{}
+	ctx := NewContext(context.Background(), log)
+
+	tx, _ := db.BeginTx(ctx, nil)
+	tx.ExecContext(ctx, "INSERT INTO loans (nik) VALUES ('3171234567890001')")
+	if len(log.Activities) != 0 {
+		t.Errorf("Expected the insert to be held until the transaction ends, but got %+v", log.Activities)
+	}
+	tx.Rollback()
+
+	tx, _ = db.BeginTx(ctx, nil)
+	tx.ExecContext(ctx, "UPDATE loans SET status = 'approved'")
+	tx.Commit()
+
+	if len(log.Activities) != 2 {
+		t.Fatalf("Expected 2 activities, but got %d", len(log.Activities))
+	}
+	if rolledBack := log.Activities[0]; rolledBack.Status != "failed" || rolledBack.Error == nil || rolledBack.Error.Message != errSQLRollback.Error() {
+		t.Errorf("Expected the rolled back insert to fail, but got %+v", rolledBack)
+	}
+	if committed := log.Activities[1]; committed.Status != "success" || committed.SQL.Operation != "UPDATE" {
+		t.Errorf("Expected the committed update to succeed, but got %+v", committed)
+	}
+}
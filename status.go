@@ -0,0 +1,172 @@
+package audittrail
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ActivityStatus is the status of an action log.
+type ActivityStatus string
+
+const (
+	// ActivityStatusSuccess is used when the action succeeded.
+	ActivityStatusSuccess ActivityStatus = "success"
+
+	// ActivityStatusFailed is used when the action failed. Segments start as failed until they succeed.
+	ActivityStatusFailed ActivityStatus = "failed"
+
+	// ActivityStatusPending is used when the action continues asynchronously, e.g. a disbursement waiting for the bank callback.
+	ActivityStatusPending ActivityStatus = "pending"
+
+	// ActivityStatusPartial is used when only part of the action succeeded, e.g. a bulk step.
+	ActivityStatusPartial ActivityStatus = "partial"
+
+	// ActivityStatusSkipped is used when the action was not needed.
+	ActivityStatusSkipped ActivityStatus = "skipped"
+
+	// ActivityStatusCompensated is used when the effect of the action was undone later.
+	ActivityStatusCompensated ActivityStatus = "compensated"
+)
+
+// activityStatusTransitions is used to store the statuses an ended action log can be updated to.
+var activityStatusTransitions = map[ActivityStatus][]ActivityStatus{
+	ActivityStatusPending:     {ActivityStatusSuccess, ActivityStatusFailed, ActivityStatusPartial, ActivityStatusSkipped},
+	ActivityStatusPartial:     {ActivityStatusSuccess, ActivityStatusFailed, ActivityStatusCompensated},
+	ActivityStatusSuccess:     {ActivityStatusCompensated},
+	ActivityStatusFailed:      nil,
+	ActivityStatusSkipped:     nil,
+	ActivityStatusCompensated: nil,
+}
+
+// ErrInvalidStatusTransition is returned when an action log can not be updated to the status.
+var ErrInvalidStatusTransition = errors.New("invalid activity status transition")
+
+// IsValid determines whether the status is a known status.
+func (s ActivityStatus) IsValid() bool {
+	_, ok := activityStatusTransitions[s]
+	return ok
+}
+
+// IsFinal determines whether an action log with the status can not be updated anymore.
+func (s ActivityStatus) IsFinal() bool {
+	return s.IsValid() && len(activityStatusTransitions[s]) == 0
+}
+
+// CanTransitionTo determines whether an ended action log with the status can be updated to the next status.
+func (s ActivityStatus) CanTransitionTo(next ActivityStatus) bool {
+	for _, status := range activityStatusTransitions[s] {
+		if status == next {
+			return true
+		}
+	}
+	return false
+}
+
+// ActivityStatusUpdate is used to store a later status of an action log of an earlier event log.
+type ActivityStatusUpdate struct {
+
+	// EventID is used to store the event ID of the event log of the action log.
+	EventID string `json:"eventId"`
+
+	// ActivityID is used to store the ID of the action log.
+	ActivityID string `json:"activityId"`
+
+	// From is used to store the status the action log is expected to have, it is not checked when empty.
+	// ReconcileActivityStatus rejects the update when the action log has another status.
+	From ActivityStatus `json:"from,omitempty"`
+
+	// Status is used to store the new status of the action log.
+	Status ActivityStatus `json:"status"`
+
+	// Reason is used to store why the status changed, e.g. the reference of the bank callback.
+	Reason string `json:"reason,omitempty"`
+
+	// Error is used to store why the action log failed.
+	Error *ErrorDetail `json:"error,omitempty"`
+
+	// Timestamp is used to store the time of the status update.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// To validate the status update.
+func (u ActivityStatusUpdate) validate() error {
+	if u.EventID == "" || u.ActivityID == "" {
+		return errors.New("activity status update requires the event ID and the activity ID")
+	}
+	if !u.Status.IsValid() {
+		return fmt.Errorf("unknown activity status %q", u.Status)
+	}
+	if u.From != "" && !u.From.CanTransitionTo(u.Status) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, u.From, u.Status)
+	}
+	return nil
+}
+
+// To record a later status of an action log of an earlier event log, published with this event log.
+// The timestamp defaults to now.
+//
+// Example:
+//
+//	err := activitylog.FromContext(ctx).UpdateActivityStatus(activitylog.ActivityStatusUpdate{
+//		EventID:    disbursement.EventID,
+//		ActivityID: disbursement.ActivityID,
+//		From:       activitylog.ActivityStatusPending,
+//		Status:     activitylog.ActivityStatusSuccess,
+//		Reason:     callback.Reference,
+//	})
+func (c *Transaction) UpdateActivityStatus(update ActivityStatusUpdate) error {
+	if err := update.validate(); err != nil {
+		return err
+	}
+	if c == nil {
+		return nil
+	}
+	if update.Timestamp.IsZero() {
+		update.Timestamp = time.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.StatusUpdates = append(c.StatusUpdates, update)
+	return nil
+}
+
+// ReconcileActivityStatus applies the status updates of the event logs to their action logs,
+// in timestamp order, so each action log holds its final status.
+// It returns the updates whose action log is not found, does not have the From status, or whose transition is not valid.
+func ReconcileActivityStatus(transactions []*Transaction) []ActivityStatusUpdate {
+	type activityKey struct {
+		eventID    string
+		activityID string
+	}
+
+	activities := make(map[activityKey]*Activity)
+	var updates []ActivityStatusUpdate
+	for _, tx := range transactions {
+		for i := range tx.Activities {
+			activity := &tx.Activities[i]
+			activities[activityKey{tx.EventID, activity.ID}] = activity
+		}
+		updates = append(updates, tx.StatusUpdates...)
+	}
+	sort.SliceStable(updates, func(i, j int) bool {
+		return updates[i].Timestamp.Before(updates[j].Timestamp)
+	})
+
+	var rejected []ActivityStatusUpdate
+	for _, update := range updates {
+		activity := activities[activityKey{update.EventID, update.ActivityID}]
+		if activity == nil || update.From != "" && update.From != activity.Status || !activity.Status.CanTransitionTo(update.Status) {
+			rejected = append(rejected, update)
+			continue
+		}
+
+		activity.Status = update.Status
+		if update.Error != nil {
+			activity.Error = update.Error
+		}
+	}
+	return rejected
+}
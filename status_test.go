@@ -0,0 +1,110 @@
+package audittrail
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActivityStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from    ActivityStatus
+		to      ActivityStatus
+		allowed bool
+	}{
+		{ActivityStatusPending, ActivityStatusSuccess, true},
+		{ActivityStatusPending, ActivityStatusPartial, true},
+		{ActivityStatusPartial, ActivityStatusCompensated, true},
+		{ActivityStatusSuccess, ActivityStatusCompensated, true},
+		{ActivityStatusSuccess, ActivityStatusFailed, false},
+		{ActivityStatusFailed, ActivityStatusSuccess, false},
+		{ActivityStatusCompensated, ActivityStatusSuccess, false},
+		{ActivityStatus("done"), ActivityStatusSuccess, false},
+	}
+
+	for _, test := range tests {
+		if allowed := test.from.CanTransitionTo(test.to); allowed != test.allowed {
+			t.Errorf("Expected %s to %s to be %v, but got %v", test.from, test.to, test.allowed, allowed)
+		}
+	}
+
+	if !ActivityStatusSkipped.IsFinal() || ActivityStatusPending.IsFinal() {
+		t.Errorf("Expected skipped to be final and pending not to be")
+	}
+}
+
+func TestSegmentSetStatus(t *testing.T) {
+	log := &Transaction{}
+
+	log.StartAction("disburse", "disburse loan").SetStatus(ActivityStatusPending).End()
+	log.StartAction("notify", "notify customer").SetStatus(ActivityStatus("done")).End()
+
+	if log.Activities[0].Status != ActivityStatusPending {
+		t.Errorf("Expected Status to be %s, but got %s", ActivityStatusPending, log.Activities[0].Status)
+	}
+	if log.Activities[1].Status != ActivityStatusFailed {
+		t.Errorf("Expected an unknown status to be ignored, but got %s", log.Activities[1].Status)
+	}
+}
+
+func TestUpdateActivityStatus(t *testing.T) {
+	log := &Transaction{}
+
+	err := log.UpdateActivityStatus(ActivityStatusUpdate{EventID: "event", ActivityID: "activity", From: ActivityStatusSuccess, Status: ActivityStatusPending})
+	if !errors.Is(err, ErrInvalidStatusTransition) {
+		t.Errorf("Expected %v, but got %v", ErrInvalidStatusTransition, err)
+	}
+	if err := log.UpdateActivityStatus(ActivityStatusUpdate{EventID: "event", Status: ActivityStatusSuccess}); err == nil {
+		t.Errorf("Expected an update without activity ID to fail")
+	}
+
+	if err := log.UpdateActivityStatus(ActivityStatusUpdate{EventID: "event", ActivityID: "activity", From: ActivityStatusPending, Status: ActivityStatusSuccess}); err != nil {
+		t.Fatalf("Error updating status: %v", err)
+	}
+	if payload, _ := json.Marshal(log.StatusUpdates[0]); !strings.Contains(string(payload), `"eventId":"event"`) {
+		t.Errorf("Expected the event ID to be encoded as eventId, but got %s", payload)
+	}
+	if len(log.StatusUpdates) != 1 || log.StatusUpdates[0].Timestamp.IsZero() {
+		t.Errorf("Expected the update to be recorded with a timestamp, but got %+v", log.StatusUpdates)
+	}
+	if !log.hasActivities() {
+		t.Errorf("Expected an event log with status updates to be published")
+	}
+}
+
+func TestReconcileActivityStatus(t *testing.T) {
+	disbursement := &Transaction{EventID: "disbursement"}
+	disbursement.StartAction("transfer", "transfer to bank").SetStatus(ActivityStatusPending).End()
+	disbursement.StartAction("ledger", "update ledger").Succeed().End()
+	transferID := disbursement.Activities[0].ID
+	ledgerID := disbursement.Activities[1].ID
+
+	now := time.Now()
+	callback := &Transaction{EventID: "callback"}
+	callback.UpdateActivityStatus(ActivityStatusUpdate{EventID: "disbursement", ActivityID: transferID, Status: ActivityStatusSuccess, Timestamp: now})
+	callback.UpdateActivityStatus(ActivityStatusUpdate{EventID: "disbursement", ActivityID: transferID, Status: ActivityStatusCompensated, Timestamp: now.Add(time.Hour)})
+	callback.UpdateActivityStatus(ActivityStatusUpdate{EventID: "disbursement", ActivityID: ledgerID, Status: ActivityStatusPending, Timestamp: now})
+	callback.UpdateActivityStatus(ActivityStatusUpdate{EventID: "disbursement", ActivityID: "missing", Status: ActivityStatusSuccess, Timestamp: now})
+	callback.UpdateActivityStatus(ActivityStatusUpdate{EventID: "disbursement", ActivityID: ledgerID, From: ActivityStatusPartial, Status: ActivityStatusCompensated, Timestamp: now.Add(time.Hour)})
+
+	var consumed []*Transaction
+	for _, log := range []*Transaction{callback, disbursement} {
+		result := &Transaction{}
+		json.Unmarshal(log.GetPayloadTransaction(), result)
+		consumed = append(consumed, result)
+	}
+
+	rejected := ReconcileActivityStatus(consumed)
+
+	if status := consumed[1].Activities[0].Status; status != ActivityStatusCompensated {
+		t.Errorf("Expected Status to be %s, but got %s", ActivityStatusCompensated, status)
+	}
+	if status := consumed[1].Activities[1].Status; status != ActivityStatusSuccess {
+		t.Errorf("Expected Status to be %s, but got %s", ActivityStatusSuccess, status)
+	}
+	if len(rejected) != 3 {
+		t.Errorf("Expected 3 rejected updates, but got %+v", rejected)
+	}
+}
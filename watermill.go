@@ -7,17 +7,14 @@ import (
 )
 
 func NewActivityLogMiddlewareWatermill(publisher message.Publisher, cfg ActivityLogConfig) message.HandlerMiddleware {
+	sanitizer := newSanitizer(cfg)
+
 	return func(h message.HandlerFunc) message.HandlerFunc {
 		return func(msg *message.Message) ([]*message.Message, error) {
 
-			trx := &Transaction{
-				ActorType:        cfg.ActorType,
-				ActorEmail:       cfg.ActorEmail,
-				Service:          cfg.ServiceName,
-				IsHtppMiddleware: false,
-				Publisher:        publisher,
-				RequestBody:      parseMessagePayload(msg),
-			}
+			trx := newTransaction(cfg, sanitizer)
+			trx.Publisher = publisher
+			trx.RequestBody = parseMessagePayload(msg)
 			trx.Start()
 			msg.SetContext(NewContext(msg.Context(), trx))
 